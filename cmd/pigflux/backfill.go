@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/nagylzs/pigflux/internal/pigflux"
+	"github.com/nagylzs/pigflux/internal/signal"
+	"github.com/nagylzs/pigflux/internal/state"
+)
+
+// runBackfill implements the backfill command, that runs a test for past time windows:
+//
+//	pigflux backfill TEST --from FROM [--to TO] --step STEP
+//	pigflux backfill TEST --resume
+//
+// The progress is stored in the --state-file after each window, so an interrupted backfill can be resumed.
+func runBackfill(args config.PigfluxCLIArgs, cmdArgs []string) error {
+	if len(cmdArgs) != 1 {
+		return errors.New("usage: pigflux backfill TEST --from FROM [--to TO] --step STEP")
+	}
+	testName := cmdArgs[0]
+	delay, err := time.ParseDuration(args.BackfillDelay)
+	if err != nil {
+		return fmt.Errorf("cannot parse backfill delay: %w", err)
+	}
+
+	args.ConfigFiles, err = configFiles(args)
+	if err != nil {
+		return err
+	}
+	configs, err := loadConfigs(args)
+	if err != nil {
+		return err
+	}
+	err = parseConfigs(&configs)
+	if err != nil {
+		return err
+	}
+	var cf config.Config
+	found := false
+	for _, c := range configs {
+		if test, ok := c.Tests[testName]; ok && !test.IsTemplate {
+			cf, found = c, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("test %s does not exist", testName)
+	}
+	// the test is checked before the first window, so that an unsuitable test does not leave a checkpoint behind
+	cf, err = pigflux.BackfillConfig(cf, testName)
+	if err != nil {
+		return err
+	}
+
+	store, err := state.Open(args.StateFile)
+	if err != nil {
+		return err
+	}
+	if args.StateFile == "" {
+		slog.Warn("--state-file is not given, the backfill cannot be resumed when interrupted")
+	}
+	cp, err := backfillCheckpoint(args, store, testName)
+	if err != nil {
+		return err
+	}
+	step, err := config.ParseDuration(cp.Step)
+	if err != nil {
+		return fmt.Errorf("cannot parse step: %w", err)
+	}
+
+	signal.SetupSignalHandler()
+	defer pigflux.Shutdown()
+	cf = pigflux.DiscoverDatabases(cf)
+	total := int((cp.To.Sub(cp.From) + step - 1) / step)
+	index := int(cp.Done.Sub(cp.From) / step)
+	for start := cp.Done; start.Before(cp.To); start = start.Add(step) {
+		if signal.IsStopping() {
+			return fmt.Errorf("backfill of %s interrupted, done until %s", testName, cp.Done.Format(timeFormat))
+		}
+		windowStarted := time.Now()
+		window := pigflux.Window{Start: start, End: start.Add(step)}
+		if window.End.After(cp.To) {
+			window.End = cp.To
+		}
+		slog.Info(fmt.Sprintf("Backfilling test %s window %d/%d: %s - %s", testName, index+1, total,
+			window.Start.Format(timeFormat), window.End.Format(timeFormat)))
+		err := pigflux.RunBackfillWindow(cf, testName, index, window)
+		if err != nil {
+			return fmt.Errorf("backfill of %s failed at window %s - %s: %w", testName,
+				window.Start.Format(timeFormat), window.End.Format(timeFormat), err)
+		}
+		cp.Done = window.End
+		err = store.SetCheckpoint(testName, cp)
+		if err != nil {
+			return err
+		}
+		index++
+		for remaining := delay - time.Since(windowStarted); remaining > 0 && cp.Done.Before(cp.To); remaining -= time.Second {
+			if signal.IsStopping() {
+				break
+			}
+			time.Sleep(min(remaining, time.Second))
+		}
+	}
+	slog.Info(fmt.Sprintf("Backfill of test %s finished, %d window(s)", testName, total))
+	return store.RemoveCheckpoint(testName)
+}
+
+// backfillCheckpoint returns the checkpoint to start from. With --resume, it is the stored checkpoint of the test,
+// otherwise a new checkpoint for --from, --to and --step. A new backfill cannot be started while an interrupted
+// one exists.
+func backfillCheckpoint(args config.PigfluxCLIArgs, store *state.Store, testName string) (state.Checkpoint, error) {
+	stored, ok := store.Checkpoint(testName)
+	if args.Resume {
+		if !ok {
+			return stored, fmt.Errorf("there is no interrupted backfill of test %s", testName)
+		}
+		slog.Info(fmt.Sprintf("Resuming backfill of test %s from %s", testName, stored.Done.Format(timeFormat)))
+		return stored, nil
+	}
+	if ok {
+		return stored, fmt.Errorf("the backfill of test %s was interrupted at %s, use --resume to continue it, or "+
+			"pigflux state reset %s to drop it", testName, stored.Done.Format(timeFormat), testName)
+	}
+	if args.From == "" || args.Step == "" {
+		return stored, errors.New("--from and --step must be given")
+	}
+	now := time.Now()
+	from, err := config.ParseTimeValue(args.From, now)
+	if err != nil {
+		return stored, fmt.Errorf("invalid --from: %w", err)
+	}
+	to, err := config.ParseTimeValue(args.To, now)
+	if err != nil {
+		return stored, fmt.Errorf("invalid --to: %w", err)
+	}
+	if !from.Before(to) {
+		return stored, errors.New("--from must be before --to")
+	}
+	step, err := config.ParseDuration(args.Step)
+	if err != nil {
+		return stored, fmt.Errorf("invalid --step: %w", err)
+	}
+	if step <= 0 {
+		return stored, errors.New("--step must be positive")
+	}
+	return state.Checkpoint{From: from, To: to, Step: args.Step, Done: from}, nil
+}
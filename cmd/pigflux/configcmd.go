@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// runConfigCommand implements the config command:
+//
+//	pigflux config render
+//	pigflux config explain TEST
+//
+// The configs are loaded the same way as for running the tests (--config, --config-dir, --env, --merge-configs).
+func runConfigCommand(args config.PigfluxCLIArgs, cmdArgs []string) error {
+	if len(cmdArgs) == 0 {
+		return errors.New("usage: pigflux config render|explain")
+	}
+	var err error
+	args.ConfigFiles, err = configFiles(args)
+	if err != nil {
+		return err
+	}
+	configs, err := loadConfigs(args)
+	if err != nil {
+		return err
+	}
+	err = parseConfigs(&configs)
+	if err != nil {
+		return err
+	}
+	switch cmdArgs[0] {
+	case "render":
+		if len(cmdArgs) > 1 {
+			return errors.New("too many arguments")
+		}
+		for i, cf := range configs {
+			out, err := cf.Render()
+			if err != nil {
+				return err
+			}
+			if len(configs) > 1 {
+				fmt.Printf("# %s\n", args.ConfigFiles[i])
+			}
+			fmt.Print(out)
+			if i < len(configs)-1 {
+				fmt.Println("---")
+			}
+		}
+	case "explain":
+		if len(cmdArgs) != 2 {
+			return errors.New("usage: pigflux config explain TEST")
+		}
+		found := false
+		for _, cf := range configs {
+			if _, ok := cf.Tests[cmdArgs[1]]; !ok {
+				continue
+			}
+			out, err := cf.Explain(cmdArgs[1])
+			if err != nil {
+				return err
+			}
+			fmt.Print(out)
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("test %s does not exist", cmdArgs[1])
+		}
+	default:
+		return fmt.Errorf("unknown config command: %s", cmdArgs[0])
+	}
+	return nil
+}
@@ -20,8 +20,11 @@ import (
 	readme "github.com/nagylzs/pigflux"
 	"github.com/nagylzs/pigflux/internal/config"
 	"github.com/nagylzs/pigflux/internal/pigflux"
+	"github.com/nagylzs/pigflux/internal/redact"
 	"github.com/nagylzs/pigflux/internal/signal"
+	"github.com/nagylzs/pigflux/internal/state"
 	"github.com/nagylzs/pigflux/internal/version"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -52,6 +55,24 @@ func main() {
 		os.Exit(0)
 	}
 
+	if args.ListPacks {
+		err = config.ListPacks()
+		if err != nil {
+			println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if args.ShowPack != "" {
+		err = config.ShowPack(args.ShowPack)
+		if err != nil {
+			println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	cnt := 0
 	if args.Debug {
 		cnt++
@@ -80,14 +101,37 @@ func main() {
 	}
 
 	lw := os.Stderr
+	redact.AddKeys(args.SensitiveKeys...)
 	h := slog.New(
-		tint.NewHandler(lw, &tint.Options{
+		redact.NewHandler(tint.NewHandler(lw, &tint.Options{
 			NoColor: !isatty.IsTerminal(lw.Fd()),
 			Level:   programLevel,
-		}),
+		})),
 	)
 	slog.SetDefault(h)
 
+	config.SetSecretsKeyFile(args.SecretsKeyFile)
+	config.SetEnvironment(args.Env)
+	if len(posArgs) > 1 {
+		switch posArgs[1] {
+		case "secrets":
+			err = runSecrets(args, posArgs[2:])
+		case "config":
+			err = runConfigCommand(args, posArgs[2:])
+		case "state":
+			err = runStateCommand(args, posArgs[2:])
+		case "backfill":
+			err = runBackfill(args, posArgs[2:])
+		default:
+			err = fmt.Errorf("unknown command: %s", posArgs[1])
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	signal.SetupSignalHandler()
 
 	go func() {
@@ -100,6 +144,7 @@ func main() {
 	for !signal.IsStopping() {
 		time.Sleep(time.Second)
 	}
+	pigflux.Shutdown()
 
 	if err != nil {
 		slog.Error(err.Error())
@@ -114,27 +159,14 @@ func runMain(args config.PigfluxCLIArgs, posArgs []string) error {
 		return fmt.Errorf("cannot parse wait time: %v", err.Error())
 	}
 
-	if args.ConfigFiles == nil || len(args.ConfigFiles) == 0 {
-		args.ConfigFiles = make([]string, 0)
-	}
-	for _, cd := range args.ConfigDirs {
-		cfs, err := listConfigFiles(cd)
-		if err != nil {
-			return err
-		}
-		args.ConfigFiles = append(args.ConfigFiles, cfs...)
-	}
-	if len(args.ConfigFiles) == 0 {
-		return errors.New("no config files specified")
+	args.ConfigFiles, err = configFiles(args)
+	if err != nil {
+		return err
 	}
 
-	configs := make([]config.Config, len(args.ConfigFiles))
-	for _, cf := range args.ConfigFiles {
-		cfg, err := config.LoadConfig(cf)
-		if err != nil {
-			return fmt.Errorf("error loading config %s: %w", cf, err)
-		}
-		configs = append(configs, cfg)
+	configs, err := loadConfigs(args)
+	if err != nil {
+		return err
 	}
 
 	err = parseConfigs(&configs)
@@ -142,6 +174,21 @@ func runMain(args config.PigfluxCLIArgs, posArgs []string) error {
 		return err
 	}
 
+	store, err := state.Open(args.StateFile)
+	if err != nil {
+		return err
+	}
+	pigflux.SetStateStore(store)
+	if args.StateFile == "" {
+		for _, cf := range configs {
+			for name, test := range cf.Tests {
+				if test.Watermark.Enabled() && !test.IsTemplate {
+					slog.Warn(fmt.Sprintf("Test %s has a watermark, but --state-file is not given, it is kept in memory only", name))
+				}
+			}
+		}
+	}
+
 	// println(fmt.Sprintf("%v", configs))
 	index := 0
 	for args.Count < 0 || index < args.Count {
@@ -152,8 +199,9 @@ func runMain(args config.PigfluxCLIArgs, posArgs []string) error {
 			slog.Info(fmt.Sprintf("Pass %d started", index+1))
 		}
 		started := time.Now()
+		pass := pigflux.Pass{Index: index, Start: started, Interval: wait}
 		for _, cf := range configs {
-			err := runConfig(cf)
+			err := runConfig(pigflux.DiscoverDatabases(cf), pass)
 			if err != nil {
 				slog.Error(err.Error())
 			}
@@ -179,6 +227,48 @@ func runMain(args config.PigfluxCLIArgs, posArgs []string) error {
 	return nil
 }
 
+// loadConfigs loads the config files. When --merge-configs is given, then all files are merged into a single config.
+func loadConfigs(args config.PigfluxCLIArgs) ([]config.Config, error) {
+	configs := make([]config.Config, 0, len(args.ConfigFiles))
+	if args.MergeConfigs {
+		cfg, err := config.LoadConfigs(args.ConfigFiles)
+		if err != nil {
+			return nil, fmt.Errorf("error loading configs: %w", err)
+		}
+		configs = append(configs, cfg)
+	} else {
+		for _, cf := range args.ConfigFiles {
+			cfg, err := config.LoadConfig(cf)
+			if err != nil {
+				return nil, fmt.Errorf("error loading config %s: %w", cf, err)
+			}
+			configs = append(configs, cfg)
+		}
+	}
+	for _, cfg := range configs {
+		redact.AddKeys(cfg.SensitiveKeys...)
+		redact.AddSecrets(cfg.Secrets()...)
+	}
+	return configs, nil
+}
+
+// configFiles returns the files given with --config, and the files found in the directories given with --config-dir.
+func configFiles(args config.PigfluxCLIArgs) ([]string, error) {
+	result := make([]string, 0)
+	result = append(result, args.ConfigFiles...)
+	for _, cd := range args.ConfigDirs {
+		cfs, err := listConfigFiles(cd)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, cfs...)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no config files specified")
+	}
+	return result, nil
+}
+
 func parseConfigs(configs *[]config.Config) error {
 	for i := 0; i < len(*configs); i++ {
 		err := (*configs)[i].ParseConfig()
@@ -206,12 +296,19 @@ func listConfigFiles(cwd string) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
+		if env := config.OverlayEnvironment(fullPath); env != "" {
+			// overlays are merged into their base file, see --env
+			if !config.IsOverlayFile(fullPath) {
+				slog.Warn("skipping config file, it looks like an overlay for another environment", "path", fullPath, "env", env)
+			}
+			continue
+		}
 		result = append(result, fullPath)
 	}
 	return result, nil
 }
 
-func runConfig(cf config.Config) error {
+func runConfig(cf config.Config, pass pigflux.Pass) error {
 	// map order values to config names
 	ord := make(map[int][]string)
 	for name, test := range cf.Tests {
@@ -236,7 +333,7 @@ func runConfig(cf config.Config) error {
 	order := slices.Sorted(maps.Keys(ord))
 	for _, o := range order {
 		for _, name := range ord[o] {
-			err := pigflux.RunTest(cf, name)
+			err := pigflux.RunTest(cf, name, pass)
 			if err != nil {
 				slog.Error(fmt.Sprintf("Error running test %s: %v", name, err))
 			}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// runSecrets implements the secrets command:
+//
+//	pigflux secrets encrypt [VALUE]
+//	pigflux secrets decrypt [VALUE]
+//	pigflux secrets rotate [FILE...]
+//
+// When VALUE is not given, it is read from the standard input. When no files are given to rotate, then
+// the files given with --config and --config-dir are rotated.
+func runSecrets(args config.PigfluxCLIArgs, cmdArgs []string) error {
+	if len(cmdArgs) == 0 {
+		return errors.New("usage: pigflux secrets encrypt|decrypt|rotate")
+	}
+	switch cmdArgs[0] {
+	case "encrypt":
+		ids, _ := config.SecretIdentities()
+		recipients, err := config.Recipients(args.Recipients, ids)
+		if err != nil {
+			return fmt.Errorf("%w, use --recipient or --secrets-key-file", err)
+		}
+		value, err := secretValue(cmdArgs[1:])
+		if err != nil {
+			return err
+		}
+		encrypted, err := config.EncryptValue(value, recipients)
+		if err != nil {
+			return err
+		}
+		fmt.Println(encrypted)
+	case "decrypt":
+		ids, err := config.SecretIdentities()
+		if err != nil {
+			return err
+		}
+		value, err := secretValue(cmdArgs[1:])
+		if err != nil {
+			return err
+		}
+		plaintext, err := config.DecryptValue(value, ids)
+		if err != nil {
+			return err
+		}
+		fmt.Println(plaintext)
+	case "rotate":
+		ids, err := config.SecretIdentities()
+		if err != nil {
+			return err
+		}
+		var newIds []age.Identity
+		if args.NewKeyFile != "" {
+			newIds, err = config.ReadIdentities(args.NewKeyFile)
+			if err != nil {
+				return err
+			}
+		} else if len(args.Recipients) == 0 {
+			return errors.New("use --new-key-file or --recipient to give the new key")
+		}
+		recipients, err := config.Recipients(args.Recipients, newIds)
+		if err != nil {
+			return err
+		}
+		files := cmdArgs[1:]
+		if len(files) == 0 {
+			files, err = configFiles(args)
+			if err != nil {
+				return err
+			}
+		}
+		for _, path := range files {
+			count, err := config.RotateSecrets(path, ids, recipients)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d value(s) re-encrypted\n", path, count)
+		}
+	default:
+		return fmt.Errorf("unknown secrets command: %s", cmdArgs[0])
+	}
+	return nil
+}
+
+// secretValue returns the value given on the command line, or reads it from the standard input.
+func secretValue(cmdArgs []string) (string, error) {
+	if len(cmdArgs) > 1 {
+		return "", errors.New("too many arguments")
+	}
+	if len(cmdArgs) == 1 {
+		return cmdArgs[0], nil
+	}
+	data, err := io.ReadAll(bufio.NewReader(os.Stdin))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
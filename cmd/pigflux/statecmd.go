@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/nagylzs/pigflux/internal/state"
+)
+
+// timeFormat is used to print the times of the state.
+const timeFormat = "2006-01-02 15:04:05"
+
+// runStateCommand implements the state command, that manages the watermarks and backfill checkpoints in the
+// --state-file:
+//
+//	pigflux state show
+//	pigflux state reset [TEST [DATABASE]]
+func runStateCommand(args config.PigfluxCLIArgs, cmdArgs []string) error {
+	if len(cmdArgs) == 0 {
+		return errors.New("usage: pigflux state show|reset")
+	}
+	if args.StateFile == "" {
+		return errors.New("--state-file is not given")
+	}
+	store, err := state.Open(args.StateFile)
+	if err != nil {
+		return err
+	}
+	switch cmdArgs[0] {
+	case "show":
+		if len(cmdArgs) > 1 {
+			return errors.New("too many arguments")
+		}
+		for _, testName := range slices.Sorted(maps.Keys(store.Watermarks)) {
+			dbs := store.Watermarks[testName]
+			for _, dbname := range slices.Sorted(maps.Keys(dbs)) {
+				v := dbs[dbname]
+				fmt.Printf("watermark\t%s\t%s\t%s\t%s\t%s\n", testName, dbname, v.Type, v.Value, v.Updated.Format(timeFormat))
+			}
+		}
+		for _, testName := range slices.Sorted(maps.Keys(store.Backfills)) {
+			cp := store.Backfills[testName]
+			fmt.Printf("backfill\t%s\t%s - %s\tstep %s\tdone until %s\t%s\n", testName, cp.From.Format(timeFormat),
+				cp.To.Format(timeFormat), cp.Step, cp.Done.Format(timeFormat), cp.Updated.Format(timeFormat))
+		}
+	case "reset":
+		if len(cmdArgs) > 3 {
+			return errors.New("usage: pigflux state reset [TEST [DATABASE]]")
+		}
+		testName, dbname := "", ""
+		if len(cmdArgs) > 1 {
+			testName = cmdArgs[1]
+		}
+		if len(cmdArgs) > 2 {
+			dbname = cmdArgs[2]
+		}
+		count, err := store.Reset(testName, dbname)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d watermark(s) and checkpoint(s) removed\n", count)
+	default:
+		return fmt.Errorf("unknown state command: %s", cmdArgs[0])
+	}
+	return nil
+}
@@ -1,21 +1,35 @@
 package config
 
 type CLIArgs struct {
-	Silent      bool   `short:"s" long:"silent" description:"Suppress all messages except errors"`
-	Verbose     bool   `short:"v" long:"verbose" description:"Be verbose (info)"`
-	Debug       bool   `short:"d" long:"debug" description:"Show debug information"`
-	ShowVersion bool   `long:"version" description:"Show version information and exit"`
-	CPUProfile  string `long:"cpu-profile" description:"Write CPU profile to file"`
-	MemProfile  string `long:"mem-profile" description:"Write MEM profile to file"`
-	NetProfile  uint16 `long:"net-profile-port" description:"Start profile http server on PORT"`
+	Silent        bool     `short:"s" long:"silent" description:"Suppress all messages except errors"`
+	Verbose       bool     `short:"v" long:"verbose" description:"Be verbose (info)"`
+	Debug         bool     `short:"d" long:"debug" description:"Show debug information"`
+	ShowVersion   bool     `long:"version" description:"Show version information and exit"`
+	CPUProfile    string   `long:"cpu-profile" description:"Write CPU profile to file"`
+	MemProfile    string   `long:"mem-profile" description:"Write MEM profile to file"`
+	NetProfile    uint16   `long:"net-profile-port" description:"Start profile http server on PORT"`
+	SensitiveKeys []string `long:"sensitive-key" description:"Mask values of this tag/attribute in log messages (can be repeated)"`
 }
 
 type PigfluxCLIArgs struct {
 	CLIArgs
 	ConfigFiles       []string `short:"c" long:"config" description:"Path to config file"`
 	ConfigDirs        []string `long:"config-dir" description:"Path to config dir, all yml files will be loaded and executed."`
+	MergeConfigs      bool     `long:"merge-configs" description:"Merge all config files into a single config, so they can share databases, influxes etc."`
+	Env               string   `long:"env" description:"Environment name, e.g. with --env prod the file pigflux.prod.yml is merged into pigflux.yml"`
 	Count             int      `long:"count" description:"Number of test runs. Defaults to 1. Use -1 to run indefinitely." default:"1"`
 	Wait              string   `short:"w" long:"wait" description:"Time to wait between test runs. Defaults to 10s" default:"10s"`
 	ShowConfigExample bool     `long:"show-config-example" description:"Show example config file"`
 	ShowReadme        bool     `long:"show-readme" description:"Show readme (markup)"`
+	ListPacks         bool     `long:"list-packs" description:"List built-in monitoring packs"`
+	ShowPack          string   `long:"show-pack" description:"Show the tests of a built-in monitoring pack" value-name:"NAME"`
+	SecretsKeyFile    string   `long:"secrets-key-file" description:"age key file for !encrypted values. Defaults to $PIGFLUX_AGE_KEY_FILE, or the key in $PIGFLUX_AGE_KEY"`
+	Recipients        []string `long:"recipient" description:"age public key for secrets encrypt/rotate. Defaults to the public key of the key file"`
+	NewKeyFile        string   `long:"new-key-file" description:"age key file whose public key is used by secrets rotate"`
+	StateFile         string   `long:"state-file" description:"JSON file that keeps the watermarks of incremental tests and the checkpoints of backfills across restarts"`
+	From              string   `long:"from" description:"Start of the backfill, e.g. 2024-01-01 or now-90d"`
+	To                string   `long:"to" description:"End of the backfill" default:"now"`
+	Step              string   `long:"step" description:"Size of the backfill windows, e.g. 1h or 1d"`
+	BackfillDelay     string   `long:"backfill-delay" description:"Minimum time between backfill windows" default:"1s"`
+	Resume            bool     `long:"resume" description:"Resume the interrupted backfill of the test from its checkpoint"`
 }
@@ -1,25 +1,72 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/nagylzs/pigflux/internal/redact"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Databases map[string]Database `yaml:"databases"`
-	Influxes  map[string]Influx   `yaml:"influxes"`
-	Influxes2 map[string]Influx2  `yaml:"influxes2"`
-	Influxes3 map[string]Influx3  `yaml:"influxes3"`
-	Tests     map[string]Test     `yaml:"tests"`
+	Databases   map[string]Database    `yaml:"databases"`
+	Influxes    map[string]Influx      `yaml:"influxes"`
+	Influxes2   map[string]Influx2     `yaml:"influxes2"`
+	Influxes3   map[string]Influx3     `yaml:"influxes3"`
+	Execs       map[string]Exec        `yaml:"execs"`
+	HTTPSources map[string]HTTPSource  `yaml:"http_sources"`
+	Prometheus  map[string]Prometheus  `yaml:"prometheus_sources"`
+	Redises     map[string]Redis       `yaml:"redis_sources"`
+	Statsds     map[string]Statsd      `yaml:"statsds"`
+	Otlps       map[string]Otlp        `yaml:"otlps"`
+	Files       map[string]File        `yaml:"files"`
+	Parquets    map[string]Parquet     `yaml:"parquets"`
+	Webhooks    map[string]Webhook     `yaml:"webhooks"`
+	Kafkas      map[string]Kafka       `yaml:"kafkas"`
+	Mqtts       map[string]Mqtt        `yaml:"mqtts"`
+	Tests       map[string]Test        `yaml:"tests"`
+	Vars        map[string]interface{} `yaml:"vars"`
+	Packs       []PackBinding          `yaml:"packs"`
+	Include     []string               `yaml:"include"`
+	// SensitiveKeys are tag, field and attribute names whose values are masked in log messages
+	SensitiveKeys []string `yaml:"sensitive_keys"`
+	// Origins tells which file defined the named items, keys are "section.name"
+	Origins map[string]string `yaml:"-"`
+	// Provenance tells where the effective values of the tests came from (test name -> key -> test name)
+	Provenance map[string]map[string]string `yaml:"-"`
+	// References are the values of ${FILE:...} and ${CMD:...} references, keys are the paths of the config keys
+	References map[string][]string `yaml:"-"`
 }
 
 type Database struct {
-	DSN       string `yaml:"dsn"`
-	Driver    string `yaml:"driver"`
-	InsertSQL string `yaml:"insert_sql"`
+	DSN       string    `yaml:"dsn"`
+	Driver    string    `yaml:"driver"`
+	InsertSQL string    `yaml:"insert_sql"`
+	Discovery Discovery `yaml:"discovery"`
+	// Vars override the vars of the config for the sql templates of tests that run on this database
+	Vars map[string]interface{} `yaml:"vars"`
+	// DatabaseName and ServerName are set for databases that were created by discovery
+	DatabaseName string `yaml:"-"`
+	ServerName   string `yaml:"-"`
+}
+
+// Discovery expands a database server into the databases found on it. It is enabled when DSNTemplate is given.
+type Discovery struct {
+	Query       string   `yaml:"query"`
+	DSNTemplate string   `yaml:"dsn_template"`
+	Include     []string `yaml:"include"`
+	Exclude     []string `yaml:"exclude"`
+}
+
+// Enabled tells if discovery is configured.
+func (d Discovery) Enabled() bool {
+	return d.DSNTemplate != ""
 }
 
 type Influx struct {
@@ -30,6 +77,18 @@ type Influx struct {
 	Password  string `yaml:"password"`
 }
 
+// UnmarshalYAML applies the default of VerifySSL, that cannot be told apart from false after decoding.
+func (i *Influx) UnmarshalYAML(node *yaml.Node) error {
+	type plain Influx
+	p := plain{VerifySSL: true}
+	err := node.Decode(&p)
+	if err != nil {
+		return err
+	}
+	*i = Influx(p)
+	return nil
+}
+
 type Influx2 struct {
 	Url    string `yaml:"url"`
 	Org    string `yaml:"org"`
@@ -41,21 +100,214 @@ type Influx3 struct {
 	Url string `yaml:"url"`
 }
 
+type Exec struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	Dir     string            `yaml:"dir"`
+	Timeout time.Duration     `yaml:"timeout" default:"30s"`
+	Format  string            `yaml:"format" default:"auto"`
+}
+
+type HTTPSource struct {
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method" default:"GET"`
+	Headers     map[string]string `yaml:"headers"`
+	Body        string            `yaml:"body"`
+	Username    string            `yaml:"username"`
+	Password    string            `yaml:"password"`
+	BearerToken string            `yaml:"bearer_token"`
+	TLS         TLS               `yaml:"tls"`
+	Timeout     time.Duration     `yaml:"timeout" default:"30s"`
+}
+
+type Prometheus struct {
+	URL         string            `yaml:"url"`
+	Headers     map[string]string `yaml:"headers"`
+	Username    string            `yaml:"username"`
+	Password    string            `yaml:"password"`
+	BearerToken string            `yaml:"bearer_token"`
+	TLS         TLS               `yaml:"tls"`
+	Timeout     time.Duration     `yaml:"timeout" default:"30s"`
+}
+
+type Redis struct {
+	Address  string        `yaml:"address"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	DB       int           `yaml:"db"`
+	UseTLS   bool          `yaml:"use_tls"`
+	TLS      TLS           `yaml:"tls"`
+	Timeout  time.Duration `yaml:"timeout" default:"10s"`
+}
+
+type Statsd struct {
+	Address       string `yaml:"address"`
+	Prefix        string `yaml:"prefix"`
+	MetricName    string `yaml:"metric_name" default:"{measurement}.{field}"`
+	MaxPacketSize int    `yaml:"max_packet_size" default:"1432"`
+	TagFormat     string `yaml:"tag_format" default:"dogstatsd"`
+}
+
+type TLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type Otlp struct {
+	Protocol           string            `yaml:"protocol" default:"http"`
+	Endpoint           string            `yaml:"endpoint"`
+	URLPath            string            `yaml:"url_path"`
+	Insecure           bool              `yaml:"insecure"`
+	Headers            map[string]string `yaml:"headers"`
+	Compression        string            `yaml:"compression"`
+	TLS                TLS               `yaml:"tls"`
+	Timeout            time.Duration     `yaml:"timeout" default:"10s"`
+	MetricName         string            `yaml:"metric_name" default:"{measurement}.{field}"`
+	ServiceName        string            `yaml:"service_name" default:"pigflux"`
+	HostName           string            `yaml:"host_name"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+}
+
+type File struct {
+	Format   string        `yaml:"format" default:"jsonl"`
+	Path     string        `yaml:"path"`
+	MaxSize  int64         `yaml:"max_size"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	Compress bool          `yaml:"compress"`
+	Retain   int           `yaml:"retain"`
+}
+
+type Parquet struct {
+	Directory   string        `yaml:"directory"`
+	MaxRows     int           `yaml:"max_rows" default:"100000"`
+	MaxAge      time.Duration `yaml:"max_age" default:"1h"`
+	Compression string        `yaml:"compression" default:"snappy"`
+}
+
+type Webhook struct {
+	URL          string            `yaml:"url"`
+	Method       string            `yaml:"method" default:"POST"`
+	Headers      map[string]string `yaml:"headers"`
+	Username     string            `yaml:"username"`
+	Password     string            `yaml:"password"`
+	BearerToken  string            `yaml:"bearer_token"`
+	TLS          TLS               `yaml:"tls"`
+	Timeout      time.Duration     `yaml:"timeout" default:"10s"`
+	Body         string            `yaml:"body"`
+	Batch        bool              `yaml:"batch"`
+	SuccessCodes []int             `yaml:"success_codes"`
+	// BodyTemplate is the parsed Body, nil when Body is not given
+	BodyTemplate *template.Template `yaml:"-"`
+}
+
+// WebhookFuncs are the functions available in webhook body templates.
+var WebhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		js, err := json.Marshal(v)
+		return string(js), err
+	},
+}
+
+type KafkaSASL struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+type Kafka struct {
+	Brokers     []string      `yaml:"brokers"`
+	Topic       string        `yaml:"topic"`
+	Key         string        `yaml:"key"`
+	Encoding    string        `yaml:"encoding" default:"line"`
+	Acks        string        `yaml:"acks" default:"all"`
+	Compression string        `yaml:"compression"`
+	UseTLS      bool          `yaml:"use_tls"`
+	TLS         TLS           `yaml:"tls"`
+	SASL        KafkaSASL     `yaml:"sasl"`
+	Timeout     time.Duration `yaml:"timeout" default:"10s"`
+}
+
+type Mqtt struct {
+	Broker        string        `yaml:"broker"`
+	ClientID      string        `yaml:"client_id"`
+	Username      string        `yaml:"username"`
+	Password      string        `yaml:"password"`
+	QoS           byte          `yaml:"qos"`
+	Retain        bool          `yaml:"retain"`
+	TLS           TLS           `yaml:"tls"`
+	Topic         string        `yaml:"topic"`
+	Format        string        `yaml:"format" default:"json"`
+	OfflineBuffer int           `yaml:"offline_buffer" default:"10000"`
+	Timeout       time.Duration `yaml:"timeout" default:"10s"`
+}
+
 type Test struct {
-	IsTemplate      bool              `yaml:"is_template"`
-	Databases       []string          `yaml:"databases"`
-	Influxes        []string          `yaml:"influxes"`
-	Influxes2       []string          `yaml:"influxes2"`
-	Influxes3       []string          `yaml:"influxes3"`
-	TargetDatabases []string          `yaml:"target_databases"`
-	Tags            map[string]string `yaml:"tags"`
-	Fields          []string          `yaml:"fields"`
-	Order           int               `yaml:"order"`
-	Timeout         time.Duration     `yaml:"timeout"`
-	Measurement     string            `yaml:"measurement"`
-	InheritFrom     string            `yaml:"inherit_from"`
-	SQL             string            `yaml:"sql"`
-	QueryTimeout    time.Duration     `yaml:"query_timeout" default:"30s"`
+	IsTemplate      bool                `yaml:"is_template"`
+	Databases       []string            `yaml:"databases"`
+	Influxes        []string            `yaml:"influxes"`
+	Influxes2       []string            `yaml:"influxes2"`
+	Influxes3       []string            `yaml:"influxes3"`
+	Statsds         []string            `yaml:"statsds"`
+	Otlps           []string            `yaml:"otlps"`
+	Files           []string            `yaml:"files"`
+	Parquets        []string            `yaml:"parquets"`
+	Webhooks        []string            `yaml:"webhooks"`
+	Kafkas          []string            `yaml:"kafkas"`
+	Mqtts           []string            `yaml:"mqtts"`
+	TargetDatabases []string            `yaml:"target_databases"`
+	Tags            Tags                `yaml:"tags"`
+	Fields          []string            `yaml:"fields"`
+	Order           int                 `yaml:"order"`
+	Timeout         time.Duration       `yaml:"timeout"`
+	Measurement     string              `yaml:"measurement"`
+	InheritFrom     StringList          `yaml:"inherit_from"`
+	SQL             string              `yaml:"sql"`
+	Variants        []SQLVariant        `yaml:"variants"`
+	Matrix          map[string][]string `yaml:"matrix"`
+	Params          map[string]Param    `yaml:"params"`
+	SQLTemplate     bool                `yaml:"sql_template"`
+	Watermark       Watermark           `yaml:"watermark"`
+	JSONRoot        string              `yaml:"json_root"`
+	JSONPaths       map[string]string   `yaml:"json_paths"`
+	QueryTimeout    time.Duration       `yaml:"query_timeout" default:"30s"`
+	// SQLTemplates are the parsed sql templates of the test (when sql_template is enabled), keyed by the query
+	// (the sql of the test or of a variant)
+	SQLTemplates map[string]*template.Template `yaml:"-"`
+	// Given are the yaml names of the properties that were given for the test (or inherited by it), so that
+	// inherited values can also be overridden with false, 0 or an empty value. When nil, then the non-empty
+	// properties are treated as given.
+	Given map[string]bool `yaml:"-"`
+}
+
+// ParseSQLTemplate parses the sql template of a test.
+func ParseSQLTemplate(name string, query string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sql template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// UnmarshalYAML records the given properties of the test.
+func (t *Test) UnmarshalYAML(node *yaml.Node) error {
+	type plain Test
+	var p plain
+	err := node.Decode(&p)
+	if err != nil {
+		return err
+	}
+	if node.Kind == yaml.MappingNode {
+		p.Given = make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			p.Given[node.Content[i].Value] = true
+		}
+	}
+	*t = Test(p)
+	return nil
 }
 
 func (t Test) Check(config *Config) error {
@@ -66,9 +318,67 @@ func (t Test) Check(config *Config) error {
 		return fmt.Errorf("no databases specified")
 	}
 	for _, dbname := range t.Databases {
-		_, ok := config.Databases[dbname]
-		if !ok {
-			return fmt.Errorf("database '%s' does not exist", dbname)
+		st, err := config.SourceType(dbname)
+		if err != nil {
+			return err
+		}
+		if st == SourcePrometheus && len(t.Fields) != 1 {
+			return fmt.Errorf("prometheus source '%s' needs exactly one field (the name of the value)", dbname)
+		}
+		if st != SourceDatabase && len(t.Variants) > 0 {
+			return fmt.Errorf("variants can only be used with SQL databases, '%s' is not one of them", dbname)
+		}
+		if st != SourceDatabase && (len(t.Params) > 0 || t.SQLTemplate || t.Watermark.Enabled()) {
+			return fmt.Errorf("params, sql_template and watermark can only be used with SQL databases, '%s' is not one of them", dbname)
+		}
+	}
+	for name, param := range t.Params {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("param '%s' is not a valid identifier", name)
+		}
+		if err := param.Check(); err != nil {
+			return fmt.Errorf("param '%s': %w", name, err)
+		}
+	}
+	if t.Watermark.Enabled() {
+		if err := t.Watermark.check(t.Params); err != nil {
+			return fmt.Errorf("watermark: %w", err)
+		}
+		if len(t.Parquets) > 0 {
+			// the rows are only buffered, the watermark would be advanced before they are written
+			return fmt.Errorf("watermark: parquets buffer the results, they cannot be used by incremental tests")
+		}
+	}
+	queries := []string{t.SQL}
+	for _, variant := range t.Variants {
+		queries = append(queries, variant.SQL)
+	}
+	for _, query := range queries {
+		for _, m := range ParamRef.FindAllStringSubmatch(query, -1) {
+			if m[1] == WatermarkParam && t.Watermark.Enabled() {
+				continue
+			}
+			if _, ok := t.Params[m[1]]; !ok {
+				return fmt.Errorf("param '%s' is used in the sql, but it is not given in params", m[1])
+			}
+		}
+	}
+	for idx, variant := range t.Variants {
+		if variant.SQL == "" {
+			return fmt.Errorf("variants[%d]: sql is not given/empty", idx)
+		}
+		if variant.Flavor != "" && variant.Flavor != FlavorMariaDB {
+			return fmt.Errorf("variants[%d]: flavor %s not supported, only %s is available", idx, variant.Flavor, FlavorMariaDB)
+		}
+		if variant.MinVersion != "" {
+			if _, err := ParseVersion(variant.MinVersion); err != nil {
+				return fmt.Errorf("variants[%d]: invalid min_version %s", idx, variant.MinVersion)
+			}
+		}
+		if variant.MaxVersion != "" {
+			if _, err := ParseVersion(variant.MaxVersion); err != nil {
+				return fmt.Errorf("variants[%d]: invalid max_version %s", idx, variant.MaxVersion)
+			}
 		}
 	}
 	if len(t.Influxes) > 0 {
@@ -95,6 +405,62 @@ func (t Test) Check(config *Config) error {
 			}
 		}
 	}
+	if len(t.Statsds) > 0 {
+		for _, statsd := range t.Statsds {
+			_, ok := config.Statsds[statsd]
+			if !ok {
+				return fmt.Errorf("statsd '%s' does not exist", statsd)
+			}
+		}
+	}
+	if len(t.Otlps) > 0 {
+		for _, otlp := range t.Otlps {
+			_, ok := config.Otlps[otlp]
+			if !ok {
+				return fmt.Errorf("otlp '%s' does not exist", otlp)
+			}
+		}
+	}
+	if len(t.Files) > 0 {
+		for _, file := range t.Files {
+			_, ok := config.Files[file]
+			if !ok {
+				return fmt.Errorf("file '%s' does not exist", file)
+			}
+		}
+	}
+	if len(t.Parquets) > 0 {
+		for _, parquet := range t.Parquets {
+			_, ok := config.Parquets[parquet]
+			if !ok {
+				return fmt.Errorf("parquet '%s' does not exist", parquet)
+			}
+		}
+	}
+	if len(t.Webhooks) > 0 {
+		for _, webhook := range t.Webhooks {
+			_, ok := config.Webhooks[webhook]
+			if !ok {
+				return fmt.Errorf("webhook '%s' does not exist", webhook)
+			}
+		}
+	}
+	if len(t.Kafkas) > 0 {
+		for _, kafka := range t.Kafkas {
+			_, ok := config.Kafkas[kafka]
+			if !ok {
+				return fmt.Errorf("kafka '%s' does not exist", kafka)
+			}
+		}
+	}
+	if len(t.Mqtts) > 0 {
+		for _, mqtt := range t.Mqtts {
+			_, ok := config.Mqtts[mqtt]
+			if !ok {
+				return fmt.Errorf("mqtt '%s' does not exist", mqtt)
+			}
+		}
+	}
 	if len(t.TargetDatabases) > 0 {
 		for _, dbname := range t.TargetDatabases {
 			db, ok := config.Databases[dbname]
@@ -106,8 +472,8 @@ func (t Test) Check(config *Config) error {
 			}
 		}
 	}
-	if len(t.Influxes) == 0 && len(t.Influxes2) == 0 && len(t.Influxes3) == 0 && len(t.TargetDatabases) == 0 {
-		return fmt.Errorf("no targets specified (influxes, influxes2, influxes3 and targetdatabase are all empty)")
+	if !t.HasTargets() {
+		return fmt.Errorf("no targets specified (influxes, influxes2, influxes3, statsds, otlps, files, parquets, webhooks, kafkas, mqtts and target_databases are all empty)")
 	}
 	if t.Fields == nil || len(t.Fields) == 0 {
 		return fmt.Errorf("no fields specified")
@@ -120,7 +486,138 @@ func (t Test) Check(config *Config) error {
 	return nil
 }
 
+// HasTargets tells if the test has at least one place to send its results to.
+func (t Test) HasTargets() bool {
+	return len(t.Influxes) > 0 || len(t.Influxes2) > 0 || len(t.Influxes3) > 0 ||
+		len(t.Statsds) > 0 || len(t.Otlps) > 0 || len(t.Files) > 0 ||
+		len(t.Parquets) > 0 || len(t.Webhooks) > 0 || len(t.Kafkas) > 0 || len(t.Mqtts) > 0 ||
+		len(t.TargetDatabases) > 0
+}
+
+// Source types, these can be listed in the databases of a test.
+const (
+	SourceDatabase   = "databases"
+	SourceInflux     = "influxes"
+	SourceInflux2    = "influxes2"
+	SourceInflux3    = "influxes3"
+	SourceExec       = "execs"
+	SourceHTTP       = "http_sources"
+	SourcePrometheus = "prometheus_sources"
+	SourceRedis      = "redis_sources"
+)
+
+// SourceType tells which section defines the named test source. The name must be unique among
+// all sections that can be used as a source.
+func (cf *Config) SourceType(name string) (string, error) {
+	found := make([]string, 0)
+	if _, ok := cf.Databases[name]; ok {
+		found = append(found, SourceDatabase)
+	}
+	if _, ok := cf.Influxes[name]; ok {
+		found = append(found, SourceInflux)
+	}
+	if _, ok := cf.Influxes2[name]; ok {
+		found = append(found, SourceInflux2)
+	}
+	if _, ok := cf.Influxes3[name]; ok {
+		found = append(found, SourceInflux3)
+	}
+	if _, ok := cf.Execs[name]; ok {
+		found = append(found, SourceExec)
+	}
+	if _, ok := cf.HTTPSources[name]; ok {
+		found = append(found, SourceHTTP)
+	}
+	if _, ok := cf.Prometheus[name]; ok {
+		found = append(found, SourcePrometheus)
+	}
+	if _, ok := cf.Redises[name]; ok {
+		found = append(found, SourceRedis)
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("database '%s' does not exist", name)
+	}
+	if len(found) > 1 {
+		return "", fmt.Errorf("database '%s' is ambiguous, it is defined in %v", name, found)
+	}
+	return found[0], nil
+}
+
+// Secrets returns the passwords and tokens of the config, so that they can be masked in log messages.
+func (cf *Config) Secrets() []string {
+	result := make([]string, 0)
+	for _, c := range cf.Influxes {
+		result = append(result, c.Password)
+	}
+	for _, c := range cf.Influxes2 {
+		result = append(result, c.Token)
+	}
+	for _, c := range cf.HTTPSources {
+		result = append(result, c.Password, c.BearerToken)
+	}
+	for _, c := range cf.Prometheus {
+		result = append(result, c.Password, c.BearerToken)
+	}
+	for _, c := range cf.Redises {
+		result = append(result, c.Password)
+	}
+	for _, c := range cf.Webhooks {
+		result = append(result, c.Password, c.BearerToken)
+	}
+	for _, c := range cf.Kafkas {
+		result = append(result, c.SASL.Password)
+	}
+	for _, c := range cf.Mqtts {
+		result = append(result, c.Password)
+	}
+	for _, c := range cf.HTTPSources {
+		result = append(result, headerSecrets(c.Headers)...)
+	}
+	for _, c := range cf.Prometheus {
+		result = append(result, headerSecrets(c.Headers)...)
+	}
+	for _, c := range cf.Otlps {
+		result = append(result, headerSecrets(c.Headers)...)
+	}
+	for _, c := range cf.Webhooks {
+		result = append(result, headerSecrets(c.Headers)...)
+	}
+	// file contents and command outputs are secrets when they are given for sensitive keys
+	for path, values := range cf.References {
+		key := path[strings.LastIndex(path, ".")+1:]
+		if idx := strings.IndexByte(key, '['); idx >= 0 {
+			key = key[:idx]
+		}
+		if redact.IsSensitiveKey(key) {
+			result = append(result, values...)
+		}
+	}
+	return result
+}
+
+// plainHeaders are the headers whose values are not secrets.
+var plainHeaders = []string{"accept", "accept-encoding", "accept-language", "cache-control", "connection",
+	"content-encoding", "content-type", "host", "user-agent"}
+
+// headerSecrets returns the values of the headers, except for the well known headers that hold no credentials.
+// Custom headers often hold API keys, e.g. X-Api-Key or X-Auth-Token.
+func headerSecrets(headers map[string]string) []string {
+	result := make([]string, 0, len(headers))
+	for name, value := range headers {
+		if !slices.Contains(plainHeaders, strings.ToLower(name)) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// LoadConfig loads a config file, together with the files it includes.
 func LoadConfig(path string) (Config, error) {
+	return LoadConfigs([]string{path})
+}
+
+// loadConfigFile loads a single config file, without its includes.
+func loadConfigFile(path string) (Config, error) {
 	var result Config
 	if path == "" {
 		return result, fmt.Errorf("config file path is not specified")
@@ -129,7 +626,24 @@ func LoadConfig(path string) (Config, error) {
 	if err != nil {
 		return result, fmt.Errorf("cannot read instance config file %s: %v", path, err.Error())
 	}
-	err = yaml.Unmarshal(yf, &result)
+	var root yaml.Node
+	err = yaml.Unmarshal(yf, &root)
+	if err != nil {
+		return result, fmt.Errorf("cannot parse YAML file %s: %v", path, err.Error())
+	}
+	err = applyOverlay(&root, path)
+	if err != nil {
+		return result, err
+	}
+	result.References = make(map[string][]string)
+	err = interpolateNode(&root, filepath.Dir(path), "", result.References)
+	if err != nil {
+		return result, fmt.Errorf("cannot load config file %s: %v", path, err.Error())
+	}
+	if len(root.Content) == 0 {
+		return result, nil
+	}
+	err = root.Decode(&result)
 	if err != nil {
 		return result, fmt.Errorf("cannot parse YAML file %s: %v", path, err.Error())
 	}
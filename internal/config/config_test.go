@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"pw.txt": "file-password\n", "host.txt": "db.example.com\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := `
+influxes:
+  i1:
+    host: ${FILE:host.txt}
+    password: ${FILE:pw.txt}
+webhooks:
+  wh:
+    url: http://localhost
+    headers:
+      Authorization: Splunk hec-token-1
+      Content-Type: application/json
+otlps:
+  ot:
+    endpoint: localhost:4317
+    headers:
+      x-api-key: otlp-key-1
+http_sources:
+  hs:
+    url: http://localhost
+    headers:
+      X-Auth-Token: http-token-1
+prometheus_sources:
+  ps:
+    url: http://localhost
+    headers:
+      X-Scope-OrgID: tenant-1
+`
+	path := filepath.Join(dir, "pigflux.yml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := cf.Secrets()
+	for _, want := range []string{"file-password", "Splunk hec-token-1", "otlp-key-1", "http-token-1", "tenant-1"} {
+		if !slices.Contains(secrets, want) {
+			t.Errorf("secrets %q do not contain %q", secrets, want)
+		}
+	}
+	for _, unwanted := range []string{"db.example.com", "application/json"} {
+		if slices.Contains(secrets, unwanted) {
+			t.Errorf("secrets %q contain %q", secrets, unwanted)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/redact"
+)
+
+// Explain describes the effective values of a test, and tells where each value came from. ParseConfig must be
+// called before.
+func (cf *Config) Explain(name string) (string, error) {
+	test, ok := cf.Tests[name]
+	if !ok {
+		return "", fmt.Errorf("test %s does not exist", name)
+	}
+	prov := cf.Provenance[name]
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "# test %s%s\n", name, cf.fileOf(name))
+	if len(test.InheritFrom) > 0 {
+		fmt.Fprintf(sb, "inherit_from: [%s]\n", strings.Join(test.InheritFrom, ", "))
+	}
+	tv := reflect.ValueOf(test)
+	for i := 0; i < tv.NumField(); i++ {
+		field := tv.Type().Field(i)
+		value := tv.Field(i)
+		if field.Name == "InheritFrom" || field.Name == "Given" || isEmptyValue(value) {
+			continue
+		}
+		key := yamlName(field)
+		if field.Name == "Tags" {
+			sb.WriteString("tags:\n")
+			for _, tag := range slices.Sorted(maps.Keys(test.Tags)) {
+				fmt.Fprintf(sb, "  %s: %s%s\n", tag, redact.String(test.Tags[tag]), cf.sourceOf(prov["tags."+tag]))
+			}
+			continue
+		}
+		source := cf.sourceOf(prov[key])
+		switch v := value.Interface().(type) {
+		case string:
+			if strings.Contains(strings.TrimRight(v, "\n"), "\n") {
+				fmt.Fprintf(sb, "%s: |%s\n", key, source)
+				for _, line := range strings.Split(strings.TrimRight(v, "\n"), "\n") {
+					fmt.Fprintf(sb, "  %s\n", redact.String(line))
+				}
+				continue
+			}
+			fmt.Fprintf(sb, "%s: %s%s\n", key, redact.String(strings.TrimRight(v, "\n")), source)
+		case []string:
+			fmt.Fprintf(sb, "%s: [%s]%s\n", key, strings.Join(v, ", "), source)
+		case time.Duration:
+			fmt.Fprintf(sb, "%s: %s%s\n", key, v, source)
+		default:
+			fmt.Fprintf(sb, "%s: %v%s\n", key, v, source)
+		}
+	}
+	return sb.String(), nil
+}
+
+func (cf *Config) fileOf(test string) string {
+	if path, ok := cf.Origins["tests."+test]; ok {
+		return " (" + path + ")"
+	}
+	return ""
+}
+
+func (cf *Config) sourceOf(test string) string {
+	if test == "" {
+		return ""
+	}
+	return "  # from " + test + cf.fileOf(test)
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// LoadConfigs loads config files with their includes, and merges them into a single config. Each file is loaded
+// only once, even when it is included multiple times. Items with the same name in the same section cannot be
+// defined in different files.
+func LoadConfigs(paths []string) (Config, error) {
+	ld := &loader{loaded: make(map[string]bool)}
+	result := Config{}
+	for _, path := range paths {
+		err := ld.load(&result, path)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+type loader struct {
+	loaded map[string]bool
+}
+
+func (ld *loader) load(result *Config, path string) error {
+	if path == "" {
+		return fmt.Errorf("config file path is not specified")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if ld.loaded[abs] {
+		return nil
+	}
+	ld.loaded[abs] = true
+
+	cf, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	cf.setOrigins(path)
+	includes := cf.Include
+	cf.Include = nil
+	err = result.Merge(cf)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include pattern %s: %w", path, pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("%s: included file %s does not exist", path, pattern)
+		}
+		slices.Sort(matches)
+		for _, match := range matches {
+			err = ld.load(result, match)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mapSections returns the yaml names and values of the named sections (databases, influxes, tests etc.) of
+// the config.
+func mapSections(v reflect.Value) map[string]reflect.Value {
+	result := make(map[string]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if field.Type.Kind() != reflect.Map || name == "" || name == "-" {
+			continue
+		}
+		result[name] = v.Field(i)
+	}
+	return result
+}
+
+// setOrigins records path as the origin of all named items of the config.
+func (cf *Config) setOrigins(path string) {
+	cf.Origins = make(map[string]string)
+	for section, m := range mapSections(reflect.ValueOf(cf).Elem()) {
+		for _, key := range m.MapKeys() {
+			cf.Origins[section+"."+key.String()] = path
+		}
+	}
+}
+
+// Merge adds the named items and the lists of another config to this config. It is an error when the same
+// section of both configs has an item with the same name.
+func (cf *Config) Merge(other Config) error {
+	if cf.Origins == nil {
+		cf.Origins = make(map[string]string)
+	}
+	dst := reflect.ValueOf(cf).Elem()
+	src := reflect.ValueOf(other)
+	srcSections := mapSections(src)
+	for section, dm := range mapSections(dst) {
+		sm := srcSections[section]
+		if sm.Len() == 0 {
+			continue
+		}
+		if dm.IsNil() {
+			dm.Set(reflect.MakeMap(dm.Type()))
+		}
+		for _, key := range sm.MapKeys() {
+			name := section + "." + key.String()
+			if dm.MapIndex(key).IsValid() {
+				return fmt.Errorf("%s is defined in both %s and %s", name, originOf(cf.Origins[name]),
+					originOf(other.Origins[name]))
+			}
+			dm.SetMapIndex(key, sm.MapIndex(key))
+			cf.Origins[name] = other.Origins[name]
+		}
+	}
+	cf.Packs = append(cf.Packs, other.Packs...)
+	cf.Include = append(cf.Include, other.Include...)
+	cf.SensitiveKeys = append(cf.SensitiveKeys, other.SensitiveKeys...)
+	if len(other.References) > 0 {
+		if cf.References == nil {
+			cf.References = make(map[string][]string)
+		}
+		for path, values := range other.References {
+			cf.References[path] = append(cf.References[path], values...)
+		}
+	}
+	return nil
+}
+
+func originOf(path string) string {
+	if path == "" {
+		return "(unknown)"
+	}
+	return path
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadConfigsMerge(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"main.yml": "include: [conf.d/*.yml, sinks.yml]\n" +
+			"databases:\n  db1: {driver: pgx}\n" +
+			"tests:\n  t1: {sql: select 1}\n" +
+			"sensitive_keys: [a]\n",
+		"conf.d/b.yml": "databases:\n  db3: {driver: mysql}\nsensitive_keys: [c]\n",
+		"conf.d/a.yml": "databases:\n  db2: {driver: sqlite}\ntests:\n  t2: {sql: select 2}\nsensitive_keys: [b]\n",
+		"conf.d/a.txt": "not: [yaml\n",
+		"sinks.yml":    "files:\n  out: {path: /tmp/out.jsonl}\npacks:\n  - include_pack: postgres/core\n",
+	})
+	cf, err := LoadConfigs([]string{filepath.Join(dir, "main.yml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cf.Databases) != 3 || cf.Databases["db2"].Driver != "sqlite" || cf.Databases["db3"].Driver != "mysql" {
+		t.Errorf("got databases %+v", cf.Databases)
+	}
+	if len(cf.Tests) != 2 || cf.Tests["t2"].SQL != "select 2" || cf.Files["out"].Path != "/tmp/out.jsonl" {
+		t.Errorf("got tests %+v and files %+v", cf.Tests, cf.Files)
+	}
+	// lists are appended in load order, glob matches are loaded in sorted order
+	if !slices.Equal(cf.SensitiveKeys, []string{"a", "b", "c"}) {
+		t.Errorf("got sensitive keys %v", cf.SensitiveKeys)
+	}
+	if len(cf.Packs) != 1 || cf.Packs[0].IncludePack != "postgres/core" {
+		t.Errorf("got packs %+v", cf.Packs)
+	}
+	for name, want := range map[string]string{
+		"databases.db1": "main.yml", "databases.db2": "conf.d/a.yml", "tests.t2": "conf.d/a.yml", "files.out": "sinks.yml",
+	} {
+		if got := cf.Origins[name]; got != filepath.Join(dir, want) {
+			t.Errorf("origin of %s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigsCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"a.yml": "include: [b.yml]\ntests:\n  a: {sql: select 1}\n",
+		"b.yml": "include: [a.yml, ./b.yml]\ntests:\n  b: {sql: select 1}\n",
+	})
+	// each file is loaded once, also when it is given on the command line
+	cf, err := LoadConfigs([]string{filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cf.Tests) != 2 {
+		t.Errorf("got tests %v", cf.Tests)
+	}
+}
+
+func TestLoadConfigsErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"main.yml":    "include: [other.yml]\ntests:\n  t: {sql: select 1}\n",
+		"other.yml":   "tests:\n  t: {sql: select 2}\n",
+		"missing.yml": "include: [nope.yml, none/*.yml]\n",
+		"pattern.yml": "include: ['[']\n",
+	})
+	_, err := LoadConfigs([]string{filepath.Join(dir, "main.yml")})
+	if err == nil || !strings.Contains(err.Error(), "tests.t is defined in both "+filepath.Join(dir, "main.yml")+
+		" and "+filepath.Join(dir, "other.yml")) {
+		t.Errorf("got %v, want an error naming both files", err)
+	}
+	_, err = LoadConfigs([]string{filepath.Join(dir, "missing.yml")})
+	if err == nil || !strings.Contains(err.Error(), "nope.yml does not exist") {
+		t.Errorf("got %v, want an error for the missing file", err)
+	}
+	_, err = LoadConfigs([]string{filepath.Join(dir, "pattern.yml")})
+	if err == nil || !strings.Contains(err.Error(), "invalid include pattern") {
+		t.Errorf("got %v, want an error for the invalid pattern", err)
+	}
+}
+
+func TestMergeReferences(t *testing.T) {
+	cf := Config{References: map[string][]string{"a.b": {"x"}}}
+	other := Config{
+		Databases:  map[string]Database{"db": {Driver: "pgx"}},
+		Vars:       map[string]interface{}{"v": 1},
+		References: map[string][]string{"a.b": {"y"}, "c": {"z"}},
+	}
+	if err := cf.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cf.References["a.b"], []string{"x", "y"}) || !slices.Equal(cf.References["c"], []string{"z"}) {
+		t.Errorf("got references %v", cf.References)
+	}
+	if cf.Databases["db"].Driver != "pgx" || cf.Vars["v"] != 1 {
+		t.Errorf("got databases %v and vars %v", cf.Databases, cf.Vars)
+	}
+	// without origins, the files are unknown
+	err := cf.Merge(Config{Vars: map[string]interface{}{"v": 2}})
+	if err == nil || err.Error() != "vars.v is defined in both (unknown) and (unknown)" {
+		t.Errorf("got %v", err)
+	}
+}
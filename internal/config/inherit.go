@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+
+	"github.com/nagylzs/set"
+	"gopkg.in/yaml.v3"
+)
+
+// ResetTag can be used on the tags of a test to drop the inherited tags. When used on a single tag value
+// (e.g. tag1: !reset), only that tag is dropped.
+const ResetTag = "!reset"
+
+// internal markers of !reset, they cannot appear in decoded YAML strings
+const (
+	resetAllKey = "\x00reset"
+	resetValue  = "\x00reset"
+)
+
+// StringList is a list of strings, that can also be given as a single string.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if node.ShortTag() == "!!null" || node.Value == "" {
+			*l = nil
+		} else {
+			*l = StringList{node.Value}
+		}
+		return nil
+	}
+	var list []string
+	err := node.Decode(&list)
+	if err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// Tags are the tags of a test. The map or its values can be marked with !reset.
+type Tags map[string]string
+
+func (t *Tags) UnmarshalYAML(node *yaml.Node) error {
+	result := make(Tags)
+	if node.Tag == ResetTag {
+		result[resetAllKey] = ""
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag != ResetTag && node.ShortTag() != "!!null" {
+			return fmt.Errorf("line %d: tags must be a map", node.Line)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Tag == ResetTag {
+				result[key.Value] = resetValue
+				continue
+			}
+			var s string
+			err := value.Decode(&s)
+			if err != nil {
+				return err
+			}
+			result[key.Value] = s
+		}
+	default:
+		return fmt.Errorf("line %d: tags must be a map", node.Line)
+	}
+	*t = result
+	return nil
+}
+
+// notInherited are the fields of Test that are never inherited.
+var notInherited = map[string]bool{"IsTemplate": true, "InheritFrom": true, "Given": true}
+
+// inheritProps resolves the inherit_from list of a test. Parents are applied in the given order, so later
+// parents override earlier ones, and the values of the test override all of them. Tags are merged. The source
+// of each effective value is recorded in the provenance of the config.
+func (cf *Config) inheritProps(name string, used *set.Set[string]) error {
+	if _, ok := cf.Provenance[name]; ok {
+		return nil
+	}
+	if cf.Provenance == nil {
+		cf.Provenance = make(map[string]map[string]string)
+	}
+	test := cf.Tests[name]
+	result := Test{IsTemplate: test.IsTemplate, InheritFrom: test.InheritFrom}
+	prov := make(map[string]string)
+	used.Add(name)
+	for _, ref := range test.InheritFrom {
+		if used.Contains(ref) {
+			return fmt.Errorf("circular reference tests.%s.inherit_from=%s (used=%v)", name, ref, used)
+		}
+		_, ok := cf.Tests[ref]
+		if !ok {
+			return fmt.Errorf("invalid reference tests.%s.inherit_from=%s", name, ref)
+		}
+		err := cf.inheritProps(ref, used)
+		if err != nil {
+			return err
+		}
+		applyProps(&result, cf.Tests[ref], prov, cf.Provenance[ref])
+	}
+	used.Remove(name)
+	applyProps(&result, test, prov, nil)
+	result.Given = make(map[string]bool)
+	for key, source := range prov {
+		if source == "" {
+			prov[key] = name
+		}
+		if !strings.HasPrefix(key, "tags.") {
+			result.Given[key] = true
+		}
+	}
+	cf.Tests[name] = result
+	cf.Provenance[name] = prov
+	return nil
+}
+
+// applyProps overrides the values of result with the given values of src (even when they are false, 0 or empty),
+// and merges the tags. The provenance of src tells where its values came from, when nil then they are the own
+// values of src.
+func applyProps(result *Test, src Test, prov map[string]string, srcProv map[string]string) {
+	source := func(key string) string {
+		if srcProv == nil {
+			return ""
+		}
+		return srcProv[key]
+	}
+	dst := reflect.ValueOf(result).Elem()
+	sv := reflect.ValueOf(src)
+	for i := 0; i < sv.NumField(); i++ {
+		field := sv.Type().Field(i)
+		if notInherited[field.Name] || field.Name == "Tags" {
+			continue
+		}
+		value := sv.Field(i)
+		key := yamlName(field)
+		if src.Given != nil && !src.Given[key] || src.Given == nil && isEmptyValue(value) {
+			continue
+		}
+		dst.Field(i).Set(value)
+		prov[key] = source(key)
+	}
+
+	if src.Tags == nil {
+		return
+	}
+	if _, ok := src.Tags[resetAllKey]; ok {
+		for key := range result.Tags {
+			delete(prov, "tags."+key)
+		}
+		result.Tags = nil
+	}
+	if result.Tags == nil {
+		result.Tags = make(Tags)
+	} else {
+		result.Tags = maps.Clone(result.Tags)
+	}
+	for key, value := range src.Tags {
+		if key == resetAllKey {
+			continue
+		}
+		if value == resetValue {
+			delete(result.Tags, key)
+			delete(prov, "tags."+key)
+			continue
+		}
+		result.Tags[key] = value
+		prov["tags."+key] = source("tags." + key)
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/nagylzs/set"
+	"gopkg.in/yaml.v3"
+)
+
+const inheritConfig = `
+tests:
+  base:
+    is_template: true
+    databases: [db]
+    measurement: base_m
+    order: 5
+    sql_template: true
+    tags: {env: prod, team: a, region: eu}
+  extra:
+    is_template: true
+    measurement: extra_m
+    fields: [x]
+    tags: {team: b}
+  child:
+    inherit_from: [base, extra]
+    order: 0
+    sql_template: false
+    sql: select 1
+    tags:
+      region: !reset
+  reset_child:
+    inherit_from: base
+    tags: !reset {only: me}
+  grandchild:
+    inherit_from: child
+    measurement: ""
+`
+
+func TestInheritProps(t *testing.T) {
+	var cf Config
+	if err := yaml.Unmarshal([]byte(inheritConfig), &cf); err != nil {
+		t.Fatal(err)
+	}
+	used := set.NewSet[string]()
+	for name := range cf.Tests {
+		if err := cf.inheritProps(name, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	child := cf.Tests["child"]
+	if child.Measurement != "extra_m" {
+		t.Errorf("measurement is %q, want the value of the last parent", child.Measurement)
+	}
+	if child.Order != 0 || child.SQLTemplate {
+		t.Errorf("order is %d and sql_template is %v, want the zero values given in the child", child.Order, child.SQLTemplate)
+	}
+	if !slices.Equal(child.Databases, []string{"db"}) || !slices.Equal(child.Fields, []string{"x"}) {
+		t.Errorf("databases %v and fields %v are not inherited", child.Databases, child.Fields)
+	}
+	if child.IsTemplate {
+		t.Error("is_template is inherited")
+	}
+	if want := (Tags{"env": "prod", "team": "b"}); !maps.Equal(child.Tags, want) {
+		t.Errorf("tags are %v, want %v", child.Tags, want)
+	}
+	prov := cf.Provenance["child"]
+	for key, want := range map[string]string{"measurement": "extra", "order": "child", "databases": "base", "tags.team": "extra"} {
+		if prov[key] != want {
+			t.Errorf("provenance of %s is %q, want %q", key, prov[key], want)
+		}
+	}
+
+	if want := (Tags{"only": "me"}); !maps.Equal(cf.Tests["reset_child"].Tags, want) {
+		t.Errorf("tags are %v, want %v", cf.Tests["reset_child"].Tags, want)
+	}
+
+	grandchild := cf.Tests["grandchild"]
+	if grandchild.Measurement != "" {
+		t.Errorf("measurement is %q, want it overridden with an empty value", grandchild.Measurement)
+	}
+	if grandchild.Order != 0 || grandchild.SQLTemplate || grandchild.SQL != "select 1" {
+		t.Errorf("got order %d, sql_template %v, sql %q, want the values of child", grandchild.Order, grandchild.SQLTemplate, grandchild.SQL)
+	}
+	if cf.Provenance["grandchild"]["order"] != "child" {
+		t.Errorf("provenance of order is %q, want child", cf.Provenance["grandchild"]["order"])
+	}
+}
+
+func TestInheritCircular(t *testing.T) {
+	var cf Config
+	data := "tests:\n  a: {inherit_from: b}\n  b: {inherit_from: a}\n"
+	if err := yaml.Unmarshal([]byte(data), &cf); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.inheritProps("a", set.NewSet[string]()); err == nil {
+		t.Error("circular references should be reported")
+	}
+}
+
+func TestPackBindingUnmarshal(t *testing.T) {
+	var binding PackBinding
+	data := "include_pack: postgres/core\nversion: 2\nprefix: pg_\ndatabases: [db]\norder: 0\n"
+	if err := yaml.Unmarshal([]byte(data), &binding); err != nil {
+		t.Fatal(err)
+	}
+	if binding.IncludePack != "postgres/core" || binding.Version != 2 || binding.Prefix != "pg_" {
+		t.Errorf("got binding %+v", binding)
+	}
+	if !slices.Equal(binding.Databases, []string{"db"}) || !binding.Given["order"] || binding.Given["prefix"] {
+		t.Errorf("got test %+v", binding.Test)
+	}
+}
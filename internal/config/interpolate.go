@@ -0,0 +1,246 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cmdTimeout is the time limit for ${CMD:...} references.
+const cmdTimeout = 30 * time.Second
+
+// interpolateNode resolves ${ENV:NAME}, ${FILE:path} and ${CMD:command} references in all scalar values of the
+// node tree, and decrypts !encrypted values. Relative file paths are relative to dir. The path of the node is used in error messages.
+// The values of file and command references are added to refs by the path of the node.
+func interpolateNode(node *yaml.Node, dir string, path string, refs map[string][]string) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for i, child := range node.Content {
+			childPath := path
+			if node.Kind == yaml.SequenceNode {
+				childPath = path + "[" + strconv.Itoa(i) + "]"
+			}
+			err := interpolateNode(child, dir, childPath, refs)
+			if err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childPath := node.Content[i].Value
+			if path != "" {
+				childPath = path + "." + childPath
+			}
+			err := interpolateNode(node.Content[i+1], dir, childPath, refs)
+			if err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag == EncryptedTag {
+			value, err := decryptSecret(node.Value)
+			if err != nil {
+				return fmt.Errorf("%s (line %d): %w", path, node.Line, err)
+			}
+			// decrypted values are not interpolated
+			node.Value = value
+			node.Tag = "!!str"
+			return nil
+		}
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		value, resolved, err := interpolate(node.Value, dir)
+		if err != nil {
+			return fmt.Errorf("%s (line %d): %w", path, node.Line, err)
+		}
+		if len(resolved) > 0 {
+			refs[path] = append(refs[path], resolved...)
+		}
+		if value != node.Value {
+			node.Value = value
+			if node.Style == 0 {
+				// plain values are resolved again, so that numbers and booleans can also be given
+				node.Tag = ""
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate resolves the references in a string. References with other prefixes are left unchanged,
+// and $${ can be used to write a literal ${. It also returns the values of the file and command references.
+func interpolate(s string, dir string) (string, []string, error) {
+	var sb strings.Builder
+	resolved := make([]string, 0)
+	for {
+		idx := strings.Index(s, "${")
+		if idx < 0 {
+			sb.WriteString(s)
+			return sb.String(), resolved, nil
+		}
+		if idx > 0 && s[idx-1] == '$' {
+			sb.WriteString(s[:idx])
+			sb.WriteString("{")
+			s = s[idx+2:]
+			continue
+		}
+		end := closingBrace(s, idx+2)
+		if end < 0 {
+			return "", nil, fmt.Errorf("unterminated reference: %s", s[idx:])
+		}
+		ref := s[idx+2 : end]
+		kind, body, found := strings.Cut(ref, ":")
+		if !found || (kind != "ENV" && kind != "FILE" && kind != "CMD") {
+			sb.WriteString(s[:end+1])
+			s = s[end+1:]
+			continue
+		}
+		value, err := resolveReference(kind, body, dir)
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot resolve ${%s}: %w", ref, err)
+		}
+		if kind != "ENV" && value != "" {
+			resolved = append(resolved, value)
+		}
+		sb.WriteString(s[:idx])
+		sb.WriteString(value)
+		s = s[end+1:]
+	}
+}
+
+// closingBrace returns the index of the brace that closes the reference starting at start, or -1.
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// resolveReference resolves a single reference. The body can end with :-default (used when the value is missing
+// or empty) or with :?message (the error message when the value is missing or empty).
+func resolveReference(kind string, body string, dir string) (string, error) {
+	hasDefault, required := false, false
+	def, message := "", ""
+	if idx := modifierIndex(kind, body); idx >= 0 {
+		switch body[idx+1] {
+		case '-':
+			hasDefault, def = true, body[idx+2:]
+		case '?':
+			required, message = true, body[idx+2:]
+		default:
+			return "", fmt.Errorf("invalid modifier %q, use :- or :?", body[idx:])
+		}
+		body = body[:idx]
+	}
+
+	var value string
+	var err error
+	switch kind {
+	case "ENV":
+		v, ok := os.LookupEnv(body)
+		if !ok {
+			err = fmt.Errorf("environment variable %s is not set", body)
+		}
+		value = v
+	case "FILE":
+		path := body
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+		var data []byte
+		data, err = os.ReadFile(path)
+		value = strings.TrimRight(string(data), "\r\n")
+	case "CMD":
+		value, err = runReferenceCommand(body)
+	}
+	if hasDefault && (err != nil || value == "") {
+		return def, nil
+	}
+	if required && (err != nil || value == "") {
+		if message == "" {
+			message = "value is required"
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s (%w)", message, err)
+		}
+		return "", fmt.Errorf("%s", message)
+	}
+	return value, err
+}
+
+// modifierIndex returns the index of the colon that starts the :- or :? modifier of a reference body, or -1.
+// Environment variable names end at the first colon. Paths and commands can contain :- themselves (for example
+// in ${VAR:-x} of a shell command), so there only a modifier outside braces and quotes is recognized.
+func modifierIndex(kind string, body string) int {
+	if kind == "ENV" {
+		idx := strings.IndexByte(body, ':')
+		if idx >= 0 && idx+1 == len(body) {
+			// a trailing colon is not a modifier, resolving it reports the error
+			return -1
+		}
+		return idx
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i+1 < len(body); i++ {
+		c := body[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case c == ':' && depth == 0 && (body[i+1] == '-' || body[i+1] == '?'):
+			return i
+		}
+	}
+	return -1
+}
+
+func runReferenceCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cmdTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("command failed: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("command failed: %w", err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
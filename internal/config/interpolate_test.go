@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("PF_TEST_SET", "value")
+	t.Setenv("PF_TEST_EMPTY", "")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		{"${ENV:PF_TEST_SET}", "value", ""},
+		{"a-${ENV:PF_TEST_SET}-b", "a-value-b", ""},
+		{"${ENV:PF_TEST_MISSING:-fallback}", "fallback", ""},
+		{"${ENV:PF_TEST_EMPTY:-fallback}", "fallback", ""},
+		{"${ENV:PF_TEST_SET:-fallback}", "value", ""},
+		{"${ENV:PF_TEST_MISSING:-a:-b}", "a:-b", ""},
+		{"${ENV:PF_TEST_MISSING:?set PF_TEST_MISSING}", "", "set PF_TEST_MISSING"},
+		{"${ENV:PF_TEST_SET:x}", "", "invalid modifier"},
+		{"${ENV:PF_TEST_MISSING}", "", "is not set"},
+		{"$${ENV:PF_TEST_SET}", "${ENV:PF_TEST_SET}", ""},
+		{"${OTHER:x}", "${OTHER:x}", ""},
+		{"${FILE:secret.txt}", "s3cret", ""},
+		{"${FILE:missing.txt:-none}", "none", ""},
+		{"${FILE:missing.txt:?no secret file}", "", "no secret file"},
+	}
+	if runtime.GOOS != "windows" {
+		tests = append(tests, []struct {
+			in   string
+			want string
+			err  string
+		}{
+			{"${CMD:echo hello}", "hello", ""},
+			// :- inside the command is part of the command
+			{"${CMD:echo ${PF_TEST_MISSING:-shell}}", "shell", ""},
+			{"${CMD:echo 'a:-b'}", "a:-b", ""},
+			{`${CMD:echo "a:?b"}`, "a:?b", ""},
+			{"${CMD:echo ${PF_TEST_MISSING:-}:-fallback}", "fallback", ""},
+			{"${CMD:exit 1:-fallback}", "fallback", ""},
+			{"${CMD:exit 1:?command failed}", "", "command failed"},
+		}...)
+	}
+	for _, tt := range tests {
+		got, _, err := interpolate(tt.in, dir)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var nonIdentifierRe = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// expandMatrices replaces tests that have a matrix with one test for each combination of the matrix values.
+// The expanded tests are named <name>_<value1>_<value2>..., values are ordered by the variable names. Templates
+// are not expanded, so the matrix can also be inherited.
+func (cf *Config) expandMatrices() error {
+	for _, name := range slices.Sorted(maps.Keys(cf.Tests)) {
+		test := cf.Tests[name]
+		if test.IsTemplate || len(test.Matrix) == 0 {
+			continue
+		}
+		vars := slices.Sorted(maps.Keys(test.Matrix))
+		for _, v := range vars {
+			if len(test.Matrix[v]) == 0 {
+				return fmt.Errorf("test '%s': matrix variable %s has no values", name, v)
+			}
+		}
+		delete(cf.Tests, name)
+		for _, combination := range combinations(vars, test.Matrix) {
+			parts := []string{name}
+			for _, v := range vars {
+				parts = append(parts, strings.Trim(nonIdentifierRe.ReplaceAllString(combination[v], "_"), "_"))
+			}
+			expandedName := strings.Join(parts, "_")
+			if _, ok := cf.Tests[expandedName]; ok {
+				return fmt.Errorf("test '%s': expanded test %s already exists", name, expandedName)
+			}
+			expanded, err := expandTest(test, combination)
+			if err != nil {
+				return fmt.Errorf("test '%s': %w", name, err)
+			}
+			cf.Tests[expandedName] = expanded
+			if prov, ok := cf.Provenance[name]; ok {
+				cf.Provenance[expandedName] = prov
+			}
+			if origin, ok := cf.Origins["tests."+name]; ok {
+				cf.Origins["tests."+expandedName] = origin
+			}
+		}
+		delete(cf.Provenance, name)
+	}
+	return nil
+}
+
+// combinations returns all combinations of the matrix values, the last variable changes the fastest.
+func combinations(vars []string, matrix map[string][]string) []map[string]string {
+	result := []map[string]string{{}}
+	for _, v := range vars {
+		next := make([]map[string]string, 0, len(result)*len(matrix[v]))
+		for _, c := range result {
+			for _, value := range matrix[v] {
+				nc := maps.Clone(c)
+				nc[v] = value
+				next = append(next, nc)
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// expandTest substitutes {matrix.NAME} in the sql, measurement and tags of the test.
+func expandTest(test Test, combination map[string]string) (Test, error) {
+	pairs := make([]string, 0, len(combination)*2)
+	for v, value := range combination {
+		pairs = append(pairs, "{matrix."+v+"}", value)
+	}
+	replacer := strings.NewReplacer(pairs...)
+	expand := func(s string) (string, error) {
+		s = replacer.Replace(s)
+		if idx := strings.Index(s, "{matrix."); idx >= 0 {
+			end := strings.Index(s[idx:], "}")
+			if end < 0 {
+				end = len(s) - idx - 1
+			}
+			return "", fmt.Errorf("unknown matrix variable %s", s[idx:idx+end+1])
+		}
+		return s, nil
+	}
+	var err error
+	result := test
+	result.Matrix = nil
+	if result.SQL, err = expand(test.SQL); err != nil {
+		return result, err
+	}
+	if result.Measurement, err = expand(test.Measurement); err != nil {
+		return result, err
+	}
+	if test.Variants != nil {
+		result.Variants = make([]SQLVariant, len(test.Variants))
+		for i, variant := range test.Variants {
+			if variant.SQL, err = expand(variant.SQL); err != nil {
+				return result, err
+			}
+			result.Variants[i] = variant
+		}
+	}
+	if test.Tags != nil {
+		result.Tags = make(Tags, len(test.Tags))
+		for key, value := range test.Tags {
+			if value, err = expand(value); err != nil {
+				return result, err
+			}
+			result.Tags[key] = value
+		}
+	}
+	return result, nil
+}
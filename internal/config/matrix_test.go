@@ -0,0 +1,130 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nagylzs/set"
+	"gopkg.in/yaml.v3"
+)
+
+const matrixConfig = `
+tests:
+  base:
+    is_template: true
+    matrix:
+      schema: [public, /var/lib/]
+    tags: {schema: "{matrix.schema}"}
+  sizes:
+    inherit_from: base
+    matrix:
+      schema: [public, /var/lib/]
+      db: [main, "v1.2"]
+    measurement: "{matrix.db}_size"
+    sql: select size from {matrix.schema}.{matrix.db}
+    variants:
+      - min_version: 10
+        sql: select size2 from {matrix.schema}.{matrix.db}
+    tags: {db: "{matrix.db}"}
+  counts:
+    inherit_from: base
+    sql: select count(*) from {matrix.schema}.t
+`
+
+// parseMatrixConfig resolves the inheritance of the tests, and expands their matrices, like ParseConfig does.
+func parseMatrixConfig(t *testing.T, data string) (Config, error) {
+	t.Helper()
+	var cf Config
+	if err := yaml.Unmarshal([]byte(data), &cf); err != nil {
+		t.Fatal(err)
+	}
+	cf.Origins = map[string]string{}
+	for name := range cf.Tests {
+		cf.Origins["tests."+name] = "tests.yml"
+	}
+	used := set.NewSet[string]()
+	for name := range cf.Tests {
+		if err := cf.inheritProps(name, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cf, cf.expandMatrices()
+}
+
+func TestMatrixExpand(t *testing.T) {
+	cf, err := parseMatrixConfig(t, matrixConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"base", "counts_public", "counts_var_lib",
+		"sizes_main_public", "sizes_main_var_lib", "sizes_v1_2_public", "sizes_v1_2_var_lib",
+	}
+	if got := slices.Sorted(maps.Keys(cf.Tests)); !slices.Equal(got, want) {
+		t.Fatalf("got tests %v, want %v", got, want)
+	}
+
+	test := cf.Tests["sizes_v1_2_var_lib"]
+	if test.SQL != "select size from /var/lib/.v1.2" || test.Measurement != "v1.2_size" {
+		t.Errorf("got sql %q and measurement %q", test.SQL, test.Measurement)
+	}
+	if len(test.Variants) != 1 || test.Variants[0].SQL != "select size2 from /var/lib/.v1.2" {
+		t.Errorf("got variants %+v", test.Variants)
+	}
+	if want := (Tags{"schema": "/var/lib/", "db": "v1.2"}); !maps.Equal(test.Tags, want) {
+		t.Errorf("got tags %v, want %v", test.Tags, want)
+	}
+	if test.Matrix != nil {
+		t.Errorf("the matrix is kept in the expanded test: %v", test.Matrix)
+	}
+	// the variants of the other expanded tests are not changed
+	if sql := cf.Tests["sizes_main_public"].Variants[0].SQL; sql != "select size2 from public.main" {
+		t.Errorf("got variant sql %q", sql)
+	}
+
+	// the matrix and the tags are inherited from the template
+	counts := cf.Tests["counts_public"]
+	if counts.SQL != "select count(*) from public.t" || counts.Tags["schema"] != "public" {
+		t.Errorf("got sql %q and tags %v", counts.SQL, counts.Tags)
+	}
+	if cf.Provenance["counts_public"]["tags.schema"] != "base" {
+		t.Errorf("provenance of tags.schema is %q, want base", cf.Provenance["counts_public"]["tags.schema"])
+	}
+	if _, ok := cf.Provenance["counts"]; ok {
+		t.Error("the provenance of the unexpanded test is kept")
+	}
+	if cf.Origins["tests.sizes_main_public"] != "tests.yml" {
+		t.Errorf("origin is %q, want tests.yml", cf.Origins["tests.sizes_main_public"])
+	}
+	// templates are not expanded
+	if tpl := cf.Tests["base"]; tpl.Tags["schema"] != "{matrix.schema}" || len(tpl.Matrix) != 1 {
+		t.Errorf("the template is changed: %+v", tpl)
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"unknown variable", "tests:\n  t:\n    matrix: {a: [x]}\n    sql: select {matrix.b}\n",
+			"unknown matrix variable {matrix.b}"},
+		{"unknown variable in tags", "tests:\n  t:\n    matrix: {a: [x]}\n    tags: {t: \"{matrix.a}{matrix.c}\"}\n",
+			"unknown matrix variable {matrix.c}"},
+		{"no values", "tests:\n  t:\n    matrix: {a: []}\n", "matrix variable a has no values"},
+		{"collision", "tests:\n  t:\n    matrix: {a: [x-y, x.y]}\n", "expanded test t_x_y already exists"},
+		{"existing test", "tests:\n  t:\n    matrix: {a: [x]}\n  t_x:\n    sql: select 1\n",
+			"expanded test t_x already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMatrixConfig(t, tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
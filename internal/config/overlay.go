@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var environment string
+
+// SetEnvironment sets the name of the environment (e.g. prod). When set, then pigflux.prod.yml is merged into
+// pigflux.yml when it is loaded. It must be called before loading configs.
+func SetEnvironment(env string) {
+	environment = env
+}
+
+// OverlayPath returns the path of the overlay file of a config file for an environment.
+func OverlayPath(path string, env string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + env + ext
+}
+
+// OverlayEnvironment returns the environment of an overlay file, when the file looks like the overlay of another
+// config file in the same directory, e.g. prod for pigflux.prod.yml when pigflux.yml exists. Otherwise it returns "".
+func OverlayEnvironment(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	env := filepath.Ext(base)
+	if env == "" || env == "." {
+		return ""
+	}
+	base = strings.TrimSuffix(base, env)
+	for _, e := range []string{".yml", ".yaml"} {
+		if _, err := os.Stat(base + e); err == nil {
+			return env[1:]
+		}
+	}
+	return ""
+}
+
+// IsOverlayFile tells if the file is an overlay of another config file in the same directory for the environment
+// set with SetEnvironment, e.g. pigflux.prod.yml is an overlay for prod when pigflux.yml exists.
+func IsOverlayFile(path string) bool {
+	return environment != "" && OverlayEnvironment(path) == environment
+}
+
+// applyOverlay merges the overlay file of the environment into the document, when the overlay file exists.
+func applyOverlay(root *yaml.Node, path string) error {
+	if environment == "" {
+		return nil
+	}
+	overlayPath := OverlayPath(path, environment)
+	data, err := os.ReadFile(overlayPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read overlay file %s: %v", overlayPath, err.Error())
+	}
+	var overlay yaml.Node
+	err = yaml.Unmarshal(data, &overlay)
+	if err != nil {
+		return fmt.Errorf("cannot parse YAML file %s: %v", overlayPath, err.Error())
+	}
+	if len(overlay.Content) == 0 {
+		return nil
+	}
+	if len(root.Content) == 0 {
+		*root = overlay
+		return nil
+	}
+	mergeNodes(root.Content[0], overlay.Content[0])
+	return nil
+}
+
+// mergeNodes deep-merges an overlay into a node. Maps are merged key by key, everything else (including lists)
+// is replaced by the overlay. A null value in the overlay removes the key. Tagged maps (e.g. !reset) replace the
+// original map.
+func mergeNodes(node *yaml.Node, overlay *yaml.Node) {
+	if node.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode || overlay.ShortTag() != "!!map" {
+		*node = *overlay
+		return
+	}
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		idx := -1
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == key.Value {
+				idx = j
+				break
+			}
+		}
+		isNull := value.Kind == yaml.ScalarNode && value.ShortTag() == "!!null"
+		switch {
+		case idx < 0 && isNull:
+		case idx < 0:
+			node.Content = append(node.Content, key, value)
+		case isNull:
+			node.Content = append(node.Content[:idx], node.Content[idx+2:]...)
+		default:
+			mergeNodes(node.Content[idx+1], value)
+		}
+	}
+}
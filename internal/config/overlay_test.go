@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeNodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		overlay string
+		want    string
+	}{
+		{"maps are merged", "a: {x: 1, y: 2}\nb: 3", "a: {y: 20, z: 30}", "a: {x: 1, y: 20, z: 30}\nb: 3"},
+		{"nested maps", "a: {b: {c: 1, d: 2}}", "a: {b: {d: 3}}", "a: {b: {c: 1, d: 3}}"},
+		{"lists are replaced", "a: [1, 2, 3]", "a: [4]", "a: [4]"},
+		{"scalars are replaced", "a: 1\nb: x", "b: y", "a: 1\nb: y"},
+		{"null removes the key", "a: 1\nb: {c: 1}", "b: null", "a: 1"},
+		{"null of a missing key", "a: 1", "b: ~", "a: 1"},
+		{"reset replaces the map", "a: {x: 1, y: 2}", "a: !reset {z: 3}", "a: {z: 3}"},
+		{"map replaces a scalar", "a: 1", "a: {b: 2}", "a: {b: 2}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base, overlay, want yaml.Node
+			for node, text := range map[*yaml.Node]string{&base: tt.base, &overlay: tt.overlay, &want: tt.want} {
+				if err := yaml.Unmarshal([]byte(text), node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mergeNodes(base.Content[0], overlay.Content[0])
+			var got, expected interface{}
+			if err := base.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := want.Decode(&expected); err != nil {
+				t.Fatal(err)
+			}
+			gotYaml, _ := yaml.Marshal(got)
+			wantYaml, _ := yaml.Marshal(expected)
+			if string(gotYaml) != string(wantYaml) {
+				t.Errorf("got\n%s\nwant\n%s", gotYaml, wantYaml)
+			}
+		})
+	}
+}
+
+func TestOverlayFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pigflux.yml":       "vars: {a: 1, b: 2}\n",
+		"pigflux.prod.yml":  "vars: {b: 20}\n",
+		"pigflux.stage.yml": "vars: {b: 30}\n",
+		"other.v2.yml":      "vars: {c: 1}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer SetEnvironment("")
+	SetEnvironment("prod")
+
+	for name, want := range map[string]string{"pigflux.yml": "", "pigflux.prod.yml": "prod", "pigflux.stage.yml": "stage", "other.v2.yml": ""} {
+		if got := OverlayEnvironment(filepath.Join(dir, name)); got != want {
+			t.Errorf("OverlayEnvironment(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if !IsOverlayFile(filepath.Join(dir, "pigflux.prod.yml")) {
+		t.Error("pigflux.prod.yml is the overlay of the selected environment")
+	}
+	if IsOverlayFile(filepath.Join(dir, "pigflux.stage.yml")) {
+		t.Error("pigflux.stage.yml is not the overlay of the selected environment")
+	}
+
+	cf, err := LoadConfig(filepath.Join(dir, "pigflux.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.Vars["a"] != 1 || cf.Vars["b"] != 20 {
+		t.Errorf("got vars %v, want the prod overlay merged", cf.Vars)
+	}
+
+	SetEnvironment("")
+	if IsOverlayFile(filepath.Join(dir, "pigflux.prod.yml")) {
+		t.Error("without an environment, no file is an overlay")
+	}
+	cf, err = LoadConfig(filepath.Join(dir, "pigflux.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.Vars["b"] != 2 {
+		t.Errorf("got vars %v, want no overlay merged", cf.Vars)
+	}
+}
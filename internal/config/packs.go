@@ -0,0 +1,279 @@
+package config
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed packs
+var packFiles embed.FS
+
+// Pack is a curated set of tests for a database server type. Packs are embedded into pigflux, and they
+// can be included in configs with include_pack.
+type Pack struct {
+	Name        string          `yaml:"name"`
+	Version     int             `yaml:"version"`
+	Description string          `yaml:"description"`
+	Driver      string          `yaml:"driver"`
+	Tests       map[string]Test `yaml:"tests"`
+	path        string
+}
+
+// PackBinding includes a pack into a config. The inline test properties (databases, influxes, tags etc.) are
+// inherited by all tests of the pack.
+type PackBinding struct {
+	IncludePack string `yaml:"include_pack"`
+	Version     int    `yaml:"version"`
+	Prefix      string `yaml:"prefix"`
+	Test        `yaml:",inline"`
+}
+
+// UnmarshalYAML decodes the binding and its inline test properties. It is needed because the UnmarshalYAML method
+// of the embedded Test would be used for the whole binding otherwise.
+func (b *PackBinding) UnmarshalYAML(node *yaml.Node) error {
+	var binding struct {
+		IncludePack string `yaml:"include_pack"`
+		Version     int    `yaml:"version"`
+		Prefix      string `yaml:"prefix"`
+	}
+	err := node.Decode(&binding)
+	if err != nil {
+		return err
+	}
+	var test Test
+	err = node.Decode(&test)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"include_pack", "version", "prefix"} {
+		delete(test.Given, key)
+	}
+	*b = PackBinding{IncludePack: binding.IncludePack, Version: binding.Version, Prefix: binding.Prefix, Test: test}
+	return nil
+}
+
+// SQLVariant is an alternative query for a range of server versions. Both bounds are inclusive, and they are
+// compared on the given components only, e.g. max_version 16 matches 16.4 too.
+type SQLVariant struct {
+	// Flavor selects servers that are compatible with the driver, but have their own versions. Only "mariadb"
+	// is supported (for the mysql driver), variants without a flavor are for the server of the driver.
+	Flavor     string `yaml:"flavor"`
+	MinVersion string `yaml:"min_version"`
+	MaxVersion string `yaml:"max_version"`
+	SQL        string `yaml:"sql"`
+}
+
+// FlavorMariaDB is the flavor of MariaDB servers, that are used with the mysql driver.
+const FlavorMariaDB = "mariadb"
+
+// Matches tells if the variant can be used for the given server flavor and version.
+func (v SQLVariant) Matches(flavor string, version string) bool {
+	if v.Flavor != flavor {
+		return false
+	}
+	if v.MinVersion != "" && CompareVersions(version, v.MinVersion) < 0 {
+		return false
+	}
+	if v.MaxVersion != "" && CompareVersions(version, v.MaxVersion) > 0 {
+		return false
+	}
+	return true
+}
+
+// ParseVersion splits a version string like "16.4" or "8.0.35-log" into its numeric components.
+func ParseVersion(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end >= 0 {
+		s = s[:end]
+	}
+	s = strings.TrimSuffix(s, ".")
+	if s == "" {
+		return nil, fmt.Errorf("invalid version")
+	}
+	parts := strings.Split(s, ".")
+	result := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version: %s", s)
+		}
+		result[i] = n
+	}
+	return result, nil
+}
+
+// CompareVersions compares a version to a bound. Only the components given in the bound are compared.
+// Unparsable versions are treated as 0.
+func CompareVersions(version string, bound string) int {
+	v, _ := ParseVersion(version)
+	b, _ := ParseVersion(bound)
+	for i, bn := range b {
+		vn := 0
+		if i < len(v) {
+			vn = v[i]
+		}
+		if vn < bn {
+			return -1
+		}
+		if vn > bn {
+			return 1
+		}
+	}
+	return 0
+}
+
+// LoadPacks returns all embedded packs, sorted by name and version.
+func LoadPacks() ([]Pack, error) {
+	result := make([]Pack, 0)
+	err := fs.WalkDir(packFiles, "packs", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (path.Ext(p) != ".yml" && path.Ext(p) != ".yaml") {
+			return nil
+		}
+		data, err := packFiles.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var pack Pack
+		err = yaml.Unmarshal(data, &pack)
+		if err != nil {
+			return fmt.Errorf("cannot parse pack %s: %w", p, err)
+		}
+		pack.path = p
+		result = append(result, pack)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
+
+// FindPack returns the named pack. When version is 0, then the latest version is returned.
+func FindPack(name string, version int) (Pack, error) {
+	packs, err := LoadPacks()
+	if err != nil {
+		return Pack{}, err
+	}
+	found := false
+	var result Pack
+	versions := make([]int, 0)
+	for _, pack := range packs {
+		if pack.Name != name {
+			continue
+		}
+		versions = append(versions, pack.Version)
+		if version == 0 || pack.Version == version {
+			result = pack
+			found = true
+		}
+	}
+	if len(versions) == 0 {
+		return result, fmt.Errorf("pack %s does not exist", name)
+	}
+	if !found {
+		return result, fmt.Errorf("pack %s version %d does not exist, available versions: %v", name, version, versions)
+	}
+	return result, nil
+}
+
+// ListPacks prints the name, version and description of the embedded packs.
+func ListPacks() error {
+	packs, err := LoadPacks()
+	if err != nil {
+		return err
+	}
+	for _, pack := range packs {
+		fmt.Printf("%s (version %d, driver %s): %s\n", pack.Name, pack.Version, pack.Driver, pack.Description)
+		for _, name := range slices.Sorted(maps.Keys(pack.Tests)) {
+			fmt.Printf("    %s\n", name)
+		}
+	}
+	return nil
+}
+
+// ShowPack prints the latest version of the named pack.
+func ShowPack(name string) error {
+	pack, err := FindPack(name, 0)
+	if err != nil {
+		return err
+	}
+	data, err := packFiles.ReadFile(pack.path)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// expandPacks adds the tests of the included packs to the config. Each binding becomes a template test
+// (named pack_<name>, with the prefix), and the tests of the pack inherit from it.
+func (cf *Config) expandPacks() error {
+	if len(cf.Packs) == 0 {
+		return nil
+	}
+	if cf.Tests == nil {
+		cf.Tests = make(map[string]Test)
+	}
+	for idx, binding := range cf.Packs {
+		if binding.IncludePack == "" {
+			return fmt.Errorf("packs[%d]: include_pack is not given/empty", idx)
+		}
+		pack, err := FindPack(binding.IncludePack, binding.Version)
+		if err != nil {
+			return fmt.Errorf("packs[%d]: %w", idx, err)
+		}
+		for _, dbname := range binding.Databases {
+			db, ok := cf.Databases[dbname]
+			if !ok {
+				return fmt.Errorf("packs[%d]: database '%s' does not exist", idx, dbname)
+			}
+			if pack.Driver != "" && db.Driver != pack.Driver {
+				return fmt.Errorf("packs[%d]: pack %s needs %s databases, but database '%s' uses %s",
+					idx, pack.Name, pack.Driver, dbname, db.Driver)
+			}
+		}
+		tplName := binding.Prefix + "pack_" + strings.ReplaceAll(pack.Name, "/", "_")
+		if _, ok := cf.Tests[tplName]; ok {
+			return fmt.Errorf("packs[%d]: test %s already exists, use a different prefix", idx, tplName)
+		}
+		tpl := binding.Test
+		tpl.IsTemplate = true
+		cf.Tests[tplName] = tpl
+		for name, test := range pack.Tests {
+			fullName := binding.Prefix + name
+			if _, ok := cf.Tests[fullName]; ok {
+				return fmt.Errorf("packs[%d]: test %s already exists, use a different prefix", idx, fullName)
+			}
+			if len(test.InheritFrom) == 0 {
+				test.InheritFrom = StringList{tplName}
+			} else {
+				refs := make(StringList, len(test.InheritFrom))
+				for i, ref := range test.InheritFrom {
+					refs[i] = binding.Prefix + ref
+				}
+				test.InheritFrom = refs
+			}
+			cf.Tests[fullName] = test
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Param types. Values of the pass_start, previous_run, database_name and test_name types are given when the
+// test is run.
+const (
+	ParamString       = "string"
+	ParamInt          = "int"
+	ParamFloat        = "float"
+	ParamBool         = "bool"
+	ParamTime         = "time"
+	ParamPassStart    = "pass_start"
+	ParamPreviousRun  = "previous_run"
+	ParamDatabaseName = "database_name"
+	ParamTestName     = "test_name"
+)
+
+var paramTypes = []string{ParamString, ParamInt, ParamFloat, ParamBool, ParamTime, ParamPassStart, ParamPreviousRun,
+	ParamDatabaseName, ParamTestName}
+
+// Param is a bind parameter of a test. It can be given as a scalar, then the type is inferred from the value.
+type Param struct {
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+}
+
+// ParamRef matches references of bind parameters in test SQL, e.g. {PARAMS[since]}
+var ParamRef = regexp.MustCompile(`\{PARAMS\[([^\[\]]+)]}`)
+
+// relative times are now, followed by signed (possibly compound) durations, e.g. now-1h30m+5m
+var relativeTimeRe = regexp.MustCompile(`^now(\s*[+-]\s*([0-9.]+[a-zµ]+)+)*$`)
+var relativePartRe = regexp.MustCompile(`([+-])\s*((?:[0-9.]+[a-zµ]+)+)`)
+var durationPartRe = regexp.MustCompile(`([0-9.]+)([a-zµ]+)`)
+
+func (p *Param) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		p.Value = node.Value
+		switch node.ShortTag() {
+		case "!!int":
+			p.Type = ParamInt
+		case "!!float":
+			p.Type = ParamFloat
+		case "!!bool":
+			p.Type = ParamBool
+		default:
+			if relativeTimeRe.MatchString(strings.TrimSpace(node.Value)) {
+				p.Type = ParamTime
+			} else {
+				p.Type = ParamString
+			}
+		}
+		return nil
+	}
+	type plain Param
+	return node.Decode((*plain)(p))
+}
+
+// Check validates the type and the value of the parameter.
+func (p Param) Check() error {
+	found := false
+	for _, t := range paramTypes {
+		if p.Type == t {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("type %s not supported, only %s are available", p.Type, strings.Join(paramTypes, ", "))
+	}
+	if p.Type == ParamPreviousRun && p.Value == "" {
+		return nil
+	}
+	_, err := p.Resolve(time.Now())
+	return err
+}
+
+// Resolve returns the value of the parameter. Relative times are relative to now. For previous_run, this is the
+// default value that is used before the first run. It returns nil for the types that are given when the test is run.
+func (p Param) Resolve(now time.Time) (interface{}, error) {
+	switch p.Type {
+	case ParamString:
+		return p.Value, nil
+	case ParamInt:
+		return strconv.ParseInt(p.Value, 10, 64)
+	case ParamFloat:
+		return strconv.ParseFloat(p.Value, 64)
+	case ParamBool:
+		return strconv.ParseBool(p.Value)
+	case ParamTime, ParamPreviousRun:
+		return ParseTimeValue(p.Value, now)
+	}
+	return nil, nil
+}
+
+// ParseTimeValue parses an absolute time (RFC 3339, "2006-01-02 15:04:05" or "2006-01-02") or a time relative
+// to now, e.g. "now", "now-1h", "now-1h30m", "now-7d+12h". Durations can use the units of time.ParseDuration, and d (day)
+// and w (week).
+func ParseTimeValue(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if relativeTimeRe.MatchString(value) {
+		result := now
+		for _, m := range relativePartRe.FindAllStringSubmatch(value, -1) {
+			d, err := ParseDuration(m[2])
+			if err != nil {
+				return now, err
+			}
+			if m[1] == "-" {
+				d = -d
+			}
+			result = result.Add(d)
+		}
+		return result, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return now, fmt.Errorf("invalid time: %s", value)
+}
+
+// ParseDuration is like time.ParseDuration, but it also accepts d (day) and w (week) as units, also in compound
+// durations like 1d12h.
+func ParseDuration(s string) (time.Duration, error) {
+	if !strings.ContainsAny(s, "dw") {
+		return time.ParseDuration(s)
+	}
+	value, negative := strings.CutPrefix(s, "-")
+	if !negative {
+		value = strings.TrimPrefix(value, "+")
+	}
+	if value == "" || durationPartRe.ReplaceAllString(value, "") != "" {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	var result time.Duration
+	for _, m := range durationPartRe.FindAllStringSubmatch(value, -1) {
+		var d time.Duration
+		switch m[2] {
+		case "d", "w":
+			n, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration: %s", s)
+			}
+			d = time.Duration(n * float64(24*time.Hour))
+			if m[2] == "w" {
+				d *= 7
+			}
+		default:
+			var err error
+			d, err = time.ParseDuration(m[0])
+			if err != nil {
+				return 0, err
+			}
+		}
+		result += d
+	}
+	if negative {
+		result = -result
+	}
+	return result, nil
+}
+
+// WatermarkParam is the name of the bind parameter that holds the watermark of the test, e.g. {PARAMS[watermark]}
+const WatermarkParam = "watermark"
+
+// Watermark makes a test incremental. After each run, the maximum value of the column is stored in the state
+// store, and it is passed as the watermark bind parameter on the next run.
+type Watermark struct {
+	// Column is the column of the result that holds the watermark. Unless listed in fields, it is not sent.
+	Column string `yaml:"column"`
+	// Initial is the value of the watermark before the first run.
+	Initial Param `yaml:"initial"`
+}
+
+// Enabled tells if the watermark is configured.
+func (w Watermark) Enabled() bool {
+	return w.Column != ""
+}
+
+func (w Watermark) check(params map[string]Param) error {
+	if !IsIdentifierLike(w.Column) {
+		return fmt.Errorf("column '%s' is not a valid identifier", w.Column)
+	}
+	if _, ok := params[WatermarkParam]; ok {
+		return fmt.Errorf("the '%s' param is reserved for the watermark", WatermarkParam)
+	}
+	switch w.Initial.Type {
+	case "":
+		return errors.New("initial is not given/empty")
+	case ParamString, ParamInt, ParamFloat, ParamTime:
+	default:
+		return fmt.Errorf("initial must be a string, int, float or time, not %s", w.Initial.Type)
+	}
+	if err := w.Initial.Check(); err != nil {
+		return fmt.Errorf("initial: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseTimeValue(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+		err   bool
+	}{
+		{"now", now, false},
+		{"now-1h", now.Add(-time.Hour), false},
+		{"now-1h30m", now.Add(-90 * time.Minute), false},
+		{"now - 1h30m", now.Add(-90 * time.Minute), false},
+		{"now-7d+12h", now.Add(-7*24*time.Hour + 12*time.Hour), false},
+		{"now-1d12h", now.Add(-36 * time.Hour), false},
+		{"now-1w", now.Add(-7 * 24 * time.Hour), false},
+		{"now+1.5h", now.Add(90 * time.Minute), false},
+		{"now-1x", now, true},
+		{"2024-04-30", time.Date(2024, 4, 30, 0, 0, 0, 0, time.Local), false},
+		{"yesterday", now, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeValue(tt.value, now)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.value, err)
+		} else if !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"90s":   90 * time.Second,
+		"1h30m": 90 * time.Minute,
+		"2d":    48 * time.Hour,
+		"1d12h": 36 * time.Hour,
+		"1w1d":  8 * 24 * time.Hour,
+		"-1d":   -24 * time.Hour,
+	} {
+		got, err := ParseDuration(value)
+		if err != nil {
+			t.Errorf("%s: %v", value, err)
+		} else if got != want {
+			t.Errorf("%s: got %v, want %v", value, got, want)
+		}
+	}
+	for _, value := range []string{"", "d", "1d x", "1dd", "1.2.3d"} {
+		if _, err := ParseDuration(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestParamTypeInference(t *testing.T) {
+	for value, want := range map[string]string{
+		"42":        ParamInt,
+		"1.5":       ParamFloat,
+		"true":      ParamBool,
+		"now":       ParamTime,
+		"now-1h30m": ParamTime,
+		"now-7d+1h": ParamTime,
+		"nowhere":   ParamString,
+		"text":      ParamString,
+	} {
+		var p Param
+		if err := yaml.Unmarshal([]byte(value), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != want {
+			t.Errorf("%s: got type %s, want %s", value, p.Type, want)
+		}
+	}
+}
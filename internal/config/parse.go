@@ -1,13 +1,24 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/nagylzs/set"
 )
 
 func (cf *Config) ParseConfig() error {
+	err := cf.expandPacks()
+	if err != nil {
+		return err
+	}
+
 	// Test identifiers
 	for name := range cf.Influxes {
 		if !IsIdentifierLike(name) {
@@ -24,6 +35,61 @@ func (cf *Config) ParseConfig() error {
 			return fmt.Errorf("invalid influx3 name: %s", name)
 		}
 	}
+	for name := range cf.Execs {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid exec name: %s", name)
+		}
+	}
+	for name := range cf.HTTPSources {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid http source name: %s", name)
+		}
+	}
+	for name := range cf.Prometheus {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid prometheus source name: %s", name)
+		}
+	}
+	for name := range cf.Redises {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid redis source name: %s", name)
+		}
+	}
+	for name := range cf.Statsds {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid statsd name: %s", name)
+		}
+	}
+	for name := range cf.Otlps {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid otlp name: %s", name)
+		}
+	}
+	for name := range cf.Files {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid file name: %s", name)
+		}
+	}
+	for name := range cf.Parquets {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid parquet name: %s", name)
+		}
+	}
+	for name := range cf.Webhooks {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid webhook name: %s", name)
+		}
+	}
+	for name := range cf.Kafkas {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid kafka name: %s", name)
+		}
+	}
+	for name := range cf.Mqtts {
+		if !IsIdentifierLike(name) {
+			return fmt.Errorf("invalid mqtt name: %s", name)
+		}
+	}
 	for name := range cf.Databases {
 		if !IsIdentifierLike(name) {
 			return fmt.Errorf("invalid database name: %s", name)
@@ -43,81 +109,287 @@ func (cf *Config) ParseConfig() error {
 			return err
 		}
 	}
+	err = cf.expandMatrices()
+	if err != nil {
+		return err
+	}
 	for dbname, db := range cf.Databases {
 		if db.Driver == "" {
 			return fmt.Errorf("database %s: driver is not given/empty", dbname)
 		}
-		if db.Driver != "pgx" && db.Driver != "mysql" && db.Driver != "sqlserver" {
-			return fmt.Errorf("database %s: driver %s not supported, only pgx, mysql, sqlserver are available", dbname, db.Driver)
+		if db.Driver != "pgx" && db.Driver != "mysql" && db.Driver != "sqlserver" && db.Driver != "sqlite" {
+			return fmt.Errorf("database %s: driver %s not supported, only pgx, mysql, sqlserver, sqlite are available", dbname, db.Driver)
 		}
-	}
-	for name := range cf.Tests {
-		err := cf.Tests[name].Check(cf)
-		if err != nil {
-			return fmt.Errorf("test '%s': %w", name, err)
+		if db.Discovery.Enabled() {
+			err := db.Discovery.check(db.Driver)
+			if err != nil {
+				return fmt.Errorf("database %s: discovery: %w", dbname, err)
+			}
+			if db.Discovery.Query == "" {
+				db.Discovery.Query = discoveryQueries[db.Driver]
+			}
+			cf.Databases[dbname] = db
 		}
 	}
-	return nil
-}
-
-func IsIdentifierLike(s string) bool {
-	ok, err := regexp.Match("[a-zA-Z][a-zA-Z0-9]*", []byte(s))
-	if err != nil {
-		panic(err)
+	for name, ex := range cf.Execs {
+		if ex.Command == "" {
+			return fmt.Errorf("exec %s: command is not given/empty", name)
+		}
+		if ex.Timeout <= 0 {
+			ex.Timeout = 30 * time.Second
+		}
+		if ex.Format == "" {
+			ex.Format = "auto"
+		}
+		if ex.Format != "auto" && ex.Format != "json" && ex.Format != "line" {
+			return fmt.Errorf("exec %s: format %s not supported, only auto, json, line are available", name, ex.Format)
+		}
+		cf.Execs[name] = ex
 	}
-	return ok
-}
-
-func (cf *Config) inheritProps(name string, used *set.Set[string]) error {
-	test := cf.Tests[name]
-	ref := test.InheritFrom
-	if ref == "" {
-		return nil
+	for name, hs := range cf.HTTPSources {
+		if hs.URL == "" {
+			return fmt.Errorf("http source %s: url is not given/empty", name)
+		}
+		if hs.Method == "" {
+			hs.Method = "GET"
+		}
+		if hs.Timeout <= 0 {
+			hs.Timeout = 30 * time.Second
+		}
+		cf.HTTPSources[name] = hs
 	}
-	if used.Contains(ref) {
-		return fmt.Errorf("circular reference tests.%s.inherit_from=%s (used=%v)", name, ref, used)
+	for name, ps := range cf.Prometheus {
+		if ps.URL == "" {
+			return fmt.Errorf("prometheus source %s: url is not given/empty", name)
+		}
+		if ps.Timeout <= 0 {
+			ps.Timeout = 30 * time.Second
+		}
+		cf.Prometheus[name] = ps
 	}
-	ihf, ok := cf.Tests[ref]
-	if !ok {
-		return fmt.Errorf("invalid reference tests.%s.inherit_from=%s", name, ref)
+	for name, rs := range cf.Redises {
+		if rs.Address == "" {
+			return fmt.Errorf("redis source %s: address is not given/empty", name)
+		}
+		if rs.Timeout <= 0 {
+			rs.Timeout = 10 * time.Second
+		}
+		cf.Redises[name] = rs
 	}
-	used.Add(ref)
-	err := cf.inheritProps(ref, used)
-	if err != nil {
-		return err
+	for name, sd := range cf.Statsds {
+		if sd.Address == "" {
+			return fmt.Errorf("statsd %s: address is not given/empty", name)
+		}
+		if sd.MetricName == "" {
+			sd.MetricName = "{measurement}.{field}"
+		}
+		if sd.MaxPacketSize <= 0 {
+			sd.MaxPacketSize = 1432
+		}
+		if sd.TagFormat == "" {
+			sd.TagFormat = "dogstatsd"
+		}
+		if sd.TagFormat != "dogstatsd" && sd.TagFormat != "influx" && sd.TagFormat != "graphite" && sd.TagFormat != "none" {
+			return fmt.Errorf("statsd %s: tag_format %s not supported, only dogstatsd, influx, graphite, none are available", name, sd.TagFormat)
+		}
+		cf.Statsds[name] = sd
 	}
-	used.Remove(ref)
-
-	if test.Databases == nil || len(test.Databases) == 0 {
-		test.Databases = ihf.Databases
+	for name, ot := range cf.Otlps {
+		if ot.Endpoint == "" {
+			return fmt.Errorf("otlp %s: endpoint is not given/empty", name)
+		}
+		if ot.Protocol == "" {
+			ot.Protocol = "http"
+		}
+		if ot.Protocol != "http" && ot.Protocol != "grpc" {
+			return fmt.Errorf("otlp %s: protocol %s not supported, only http, grpc are available", name, ot.Protocol)
+		}
+		if ot.Compression != "" && ot.Compression != "gzip" && ot.Compression != "none" {
+			return fmt.Errorf("otlp %s: compression %s not supported, only gzip, none are available", name, ot.Compression)
+		}
+		if ot.Timeout <= 0 {
+			ot.Timeout = 10 * time.Second
+		}
+		if ot.MetricName == "" {
+			ot.MetricName = "{measurement}.{field}"
+		}
+		if ot.ServiceName == "" {
+			ot.ServiceName = "pigflux"
+		}
+		cf.Otlps[name] = ot
 	}
-	if test.Influxes == nil || len(test.Influxes) == 0 {
-		test.Influxes = ihf.Influxes
+	for name, fc := range cf.Files {
+		if fc.Path == "" {
+			return fmt.Errorf("file %s: path is not given/empty", name)
+		}
+		if fc.Format == "" {
+			fc.Format = "jsonl"
+		}
+		if fc.Format != "jsonl" && fc.Format != "csv" && fc.Format != "line" {
+			return fmt.Errorf("file %s: format %s not supported, only jsonl, csv, line are available", name, fc.Format)
+		}
+		if fc.MaxSize < 0 || fc.MaxAge < 0 || fc.Retain < 0 {
+			return fmt.Errorf("file %s: max_size, max_age and retain cannot be negative", name)
+		}
+		cf.Files[name] = fc
 	}
-	if test.Influxes2 == nil || len(test.Influxes2) == 0 {
-		test.Influxes2 = ihf.Influxes2
+	for name, pq := range cf.Parquets {
+		if pq.Directory == "" {
+			return fmt.Errorf("parquet %s: directory is not given/empty", name)
+		}
+		if pq.MaxRows <= 0 {
+			pq.MaxRows = 100000
+		}
+		if pq.MaxAge <= 0 {
+			pq.MaxAge = time.Hour
+		}
+		if pq.Compression == "" {
+			pq.Compression = "snappy"
+		}
+		if pq.Compression != "snappy" && pq.Compression != "gzip" && pq.Compression != "zstd" && pq.Compression != "none" {
+			return fmt.Errorf("parquet %s: compression %s not supported, only snappy, gzip, zstd, none are available", name, pq.Compression)
+		}
+		cf.Parquets[name] = pq
 	}
-	if test.Influxes3 == nil || len(test.Influxes3) == 0 {
-		test.Influxes3 = ihf.Influxes3
+	for name, wh := range cf.Webhooks {
+		if wh.URL == "" {
+			return fmt.Errorf("webhook %s: url is not given/empty", name)
+		}
+		if wh.Method == "" {
+			wh.Method = "POST"
+		}
+		wh.Method = strings.ToUpper(wh.Method)
+		if wh.Method != "POST" && wh.Method != "PUT" && wh.Method != "PATCH" {
+			return fmt.Errorf("webhook %s: method %s not supported, only POST, PUT, PATCH are available", name, wh.Method)
+		}
+		if wh.Timeout <= 0 {
+			wh.Timeout = 10 * time.Second
+		}
+		if wh.Body != "" {
+			tpl, err := template.New(name).Funcs(WebhookFuncs).Parse(wh.Body)
+			if err != nil {
+				return fmt.Errorf("webhook %s: invalid body template: %w", name, err)
+			}
+			wh.BodyTemplate = tpl
+		}
+		cf.Webhooks[name] = wh
 	}
-	if test.TargetDatabases == nil || len(test.TargetDatabases) == 0 {
-		test.TargetDatabases = ihf.TargetDatabases
+	for name, kf := range cf.Kafkas {
+		if len(kf.Brokers) == 0 {
+			return fmt.Errorf("kafka %s: brokers are not given/empty", name)
+		}
+		if kf.Topic == "" {
+			return fmt.Errorf("kafka %s: topic is not given/empty", name)
+		}
+		if kf.Encoding == "" {
+			kf.Encoding = "line"
+		}
+		if kf.Encoding != "line" && kf.Encoding != "json" && kf.Encoding != "json_schema" {
+			return fmt.Errorf("kafka %s: encoding %s not supported, only line, json, json_schema are available", name, kf.Encoding)
+		}
+		if kf.Acks == "" {
+			kf.Acks = "all"
+		}
+		if kf.Acks != "none" && kf.Acks != "one" && kf.Acks != "all" {
+			return fmt.Errorf("kafka %s: acks %s not supported, only none, one, all are available", name, kf.Acks)
+		}
+		if kf.Compression != "" && kf.Compression != "none" && kf.Compression != "gzip" && kf.Compression != "snappy" &&
+			kf.Compression != "lz4" && kf.Compression != "zstd" {
+			return fmt.Errorf("kafka %s: compression %s not supported, only none, gzip, snappy, lz4, zstd are available", name, kf.Compression)
+		}
+		if kf.SASL.Mechanism != "" && kf.SASL.Mechanism != "plain" && kf.SASL.Mechanism != "scram-sha-256" && kf.SASL.Mechanism != "scram-sha-512" {
+			return fmt.Errorf("kafka %s: sasl mechanism %s not supported, only plain, scram-sha-256, scram-sha-512 are available", name, kf.SASL.Mechanism)
+		}
+		if kf.Timeout <= 0 {
+			kf.Timeout = 10 * time.Second
+		}
+		cf.Kafkas[name] = kf
 	}
-	if test.Tags == nil || len(test.Tags) == 0 {
-		test.Tags = ihf.Tags
+	for name, mq := range cf.Mqtts {
+		if mq.Broker == "" {
+			return fmt.Errorf("mqtt %s: broker is not given/empty", name)
+		}
+		if mq.Topic == "" {
+			return fmt.Errorf("mqtt %s: topic is not given/empty", name)
+		}
+		if mq.QoS > 2 {
+			return fmt.Errorf("mqtt %s: qos must be 0, 1 or 2", name)
+		}
+		if mq.Format == "" {
+			mq.Format = "json"
+		}
+		if mq.Format != "json" && mq.Format != "line" {
+			return fmt.Errorf("mqtt %s: format %s not supported, only json, line are available", name, mq.Format)
+		}
+		if mq.ClientID == "" {
+			// brokers disconnect clients with the same id, so the default must be unique across hosts and processes
+			suffix := make([]byte, 4)
+			_, _ = rand.Read(suffix)
+			mq.ClientID = "pigflux-" + name + "-" + hex.EncodeToString(suffix)
+		}
+		if mq.OfflineBuffer <= 0 {
+			mq.OfflineBuffer = 10000
+		}
+		if mq.Timeout <= 0 {
+			mq.Timeout = 10 * time.Second
+		}
+		cf.Mqtts[name] = mq
 	}
-	if test.Fields == nil || len(test.Fields) == 0 {
-		test.Fields = ihf.Fields
+	for name, test := range cf.Tests {
+		err := test.Check(cf)
+		if err != nil {
+			return fmt.Errorf("test '%s': %w", name, err)
+		}
+		if test.SQLTemplate && !test.IsTemplate {
+			test.SQLTemplates = make(map[string]*template.Template)
+			queries := []string{test.SQL}
+			for _, variant := range test.Variants {
+				queries = append(queries, variant.SQL)
+			}
+			for _, query := range queries {
+				if query == "" {
+					continue
+				}
+				test.SQLTemplates[query], err = ParseSQLTemplate(name, query)
+				if err != nil {
+					return fmt.Errorf("test '%s': %w", name, err)
+				}
+			}
+			cf.Tests[name] = test
+		}
 	}
-	if test.Measurement == "" {
-		test.Measurement = ihf.Measurement
+	return nil
+}
+
+// discoveryQueries are the default queries that list the databases of a server, for each driver.
+var discoveryQueries = map[string]string{
+	"pgx":       "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate",
+	"mysql":     "SHOW DATABASES",
+	"sqlserver": "SELECT name FROM sys.databases WHERE state = 0",
+}
+
+func (d Discovery) check(driver string) error {
+	if !strings.Contains(d.DSNTemplate, "{database}") {
+		return fmt.Errorf("dsn_template must contain {database}")
 	}
-	if test.SQL == "" {
-		test.SQL = ihf.SQL
+	if d.Query == "" {
+		if _, ok := discoveryQueries[driver]; !ok {
+			return fmt.Errorf("query is not given/empty, and there is no default query for driver %s", driver)
+		}
 	}
-	if test.Order == 0 {
-		test.Order = ihf.Order
+	for _, expr := range append(slices.Clone(d.Include), d.Exclude...) {
+		_, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression %s: %w", expr, err)
+		}
 	}
-	cf.Tests[name] = test
 	return nil
 }
+
+func IsIdentifierLike(s string) bool {
+	ok, err := regexp.Match("[a-zA-Z][a-zA-Z0-9]*", []byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return ok
+}
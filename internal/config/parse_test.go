@@ -0,0 +1,149 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWebhookParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		err     string
+	}{
+		{"defaults", Webhook{URL: "http://localhost"}, ""},
+		{"lowercase method", Webhook{URL: "http://localhost", Method: "put"}, ""},
+		{"invalid method", Webhook{URL: "http://localhost", Method: "GET"}, "method GET not supported"},
+		{"template", Webhook{URL: "http://localhost", Body: `{{json .Fields}}`}, ""},
+		{"invalid template", Webhook{URL: "http://localhost", Body: `{{.Fields`}, "invalid body template"},
+		{"unknown function", Webhook{URL: "http://localhost", Body: `{{yaml .Fields}}`}, "invalid body template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := Config{Webhooks: map[string]Webhook{"wh": tt.webhook}}
+			err := cf.ParseConfig()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			wh := cf.Webhooks["wh"]
+			if wh.Method != strings.ToUpper(tt.webhook.Method) && !(tt.webhook.Method == "" && wh.Method == "POST") {
+				t.Errorf("method is %s", wh.Method)
+			}
+			if (wh.BodyTemplate != nil) != (tt.webhook.Body != "") {
+				t.Errorf("body template is %v, body is %q", wh.BodyTemplate, tt.webhook.Body)
+			}
+		})
+	}
+}
+
+func TestMqttDefaultClientID(t *testing.T) {
+	ids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		cf := Config{Mqtts: map[string]Mqtt{"mq": {Broker: "tcp://localhost:1883", Topic: "t"}}}
+		if err := cf.ParseConfig(); err != nil {
+			t.Fatal(err)
+		}
+		id := cf.Mqtts["mq"].ClientID
+		if !strings.HasPrefix(id, "pigflux-mq-") || len(id) != len("pigflux-mq-")+8 {
+			t.Errorf("unexpected client id %s", id)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 2 {
+		t.Error("default client ids should be unique")
+	}
+}
+
+func TestInfluxVerifySSLDefault(t *testing.T) {
+	influxes := map[string]Influx{}
+	err := yaml.Unmarshal([]byte("a:\n  host: http://a\nb:\n  host: http://b\n  verify_ssl: false\n"), &influxes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !influxes["a"].VerifySSL || influxes["b"].VerifySSL {
+		t.Errorf("got %+v, want verify_ssl true by default", influxes)
+	}
+}
+
+func TestVariantFlavorCheck(t *testing.T) {
+	for flavor, want := range map[string]string{"mariadb": "", "percona": "flavor percona not supported"} {
+		cf := Config{
+			Databases: map[string]Database{"db": {Driver: "mysql", DSN: "user@/db"}},
+			Files:     map[string]File{"out": {Path: "/tmp/out.jsonl"}},
+			Tests: map[string]Test{"test": {
+				Databases: []string{"db"},
+				Files:     []string{"out"},
+				SQL:       "SELECT 1 AS x",
+				Fields:    []string{"x"},
+				Variants:  []SQLVariant{{Flavor: flavor, SQL: "SELECT 2 AS x"}},
+			}},
+		}
+		err := cf.ParseConfig()
+		if want == "" && err != nil {
+			t.Errorf("%s: %v", flavor, err)
+		}
+		if want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("%s: got %v, want %q", flavor, err, want)
+		}
+	}
+}
+
+func TestSQLTemplateParse(t *testing.T) {
+	db := Database{Driver: "sqlite", DSN: "file::memory:", InsertSQL: "INSERT INTO t ({FIELDNAMES}) VALUES ({FIELDVALUES})"}
+	tests := []struct {
+		name string
+		test Test
+		err  string
+	}{
+		{"valid", Test{Databases: []string{"db"}, SQL: "SELECT {{.Vars.x}}", SQLTemplate: true}, ""},
+		{"invalid", Test{Databases: []string{"db"}, SQL: "SELECT {{.Vars.x", SQLTemplate: true}, "invalid sql template"},
+		{"invalid variant", Test{Databases: []string{"db"}, SQL: "SELECT 1", SQLTemplate: true,
+			Variants: []SQLVariant{{SQL: "SELECT {{if}}", MinVersion: "1"}}}, "invalid sql template"},
+		{"not a template", Test{Databases: []string{"db"}, SQL: "SELECT '{{'"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test.TargetDatabases = []string{"db"}
+			tt.test.Fields = []string{"x"}
+			cf := Config{Databases: map[string]Database{"db": db}, Tests: map[string]Test{"test": tt.test}}
+			err := cf.ParseConfig()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.test.SQLTemplate && cf.Tests["test"].SQLTemplates[tt.test.SQL] == nil {
+				t.Error("the sql template is not parsed")
+			}
+		})
+	}
+}
+
+func TestWatermarkParquets(t *testing.T) {
+	test := Test{
+		Databases: []string{"db"}, Parquets: []string{"pq"}, Fields: []string{"cnt"},
+		SQL:       "SELECT count(*) AS cnt, max(id) AS last_id FROM events WHERE id > {PARAMS[watermark]}",
+		Watermark: Watermark{Column: "last_id", Initial: Param{Type: ParamInt, Value: "0"}},
+	}
+	cf := Config{
+		Databases: map[string]Database{"db": {Driver: "sqlite", DSN: "file::memory:"}},
+		Parquets:  map[string]Parquet{"pq": {Directory: t.TempDir()}},
+		Tests:     map[string]Test{"events": test},
+	}
+	err := cf.ParseConfig()
+	if err == nil || !strings.Contains(err.Error(), "cannot be used by incremental tests") {
+		t.Errorf("got error %v, want parquets rejected for watermark tests", err)
+	}
+}
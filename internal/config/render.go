@@ -0,0 +1,98 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/nagylzs/pigflux/internal/redact"
+	"gopkg.in/yaml.v3"
+)
+
+// Render returns the config as YAML. Empty values are omitted, and secrets are masked.
+func (cf Config) Render() (string, error) {
+	data, err := yaml.Marshal(cf)
+	if err != nil {
+		return "", err
+	}
+	var doc interface{}
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return "", err
+	}
+	defaults := make(map[string]bool)
+	boolDefaults(reflect.TypeOf(cf), defaults, make(map[reflect.Type]bool))
+	doc, _ = renderValue("", doc, defaults)
+	if doc == nil {
+		return "", nil
+	}
+	buf := &strings.Builder{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	err = enc.Encode(doc)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// boolDefaults collects the bool fields that default to true (by their default tag), keyed by their yaml name.
+func boolDefaults(t reflect.Type, defaults map[string]bool, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		boolDefaults(t.Elem(), defaults, seen)
+	case reflect.Struct:
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Type.Kind() == reflect.Bool && field.Tag.Get("default") == "true" {
+				name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+				defaults[name] = true
+			}
+			boolDefaults(field.Type, defaults, seen)
+		}
+	}
+}
+
+// renderValue masks secrets, and removes empty values. The second return value tells if the value is empty.
+// Bools are only omitted when they have their default value.
+func renderValue(key string, value interface{}, defaults map[string]bool) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for k, item := range v {
+			rv, empty := renderValue(k, item, defaults)
+			if !empty {
+				result[k] = rv
+			}
+		}
+		return result, len(result) == 0
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			rv, _ := renderValue(key, item, defaults)
+			result = append(result, rv)
+		}
+		return result, len(result) == 0
+	case string:
+		if v == "" || v == "0s" {
+			// "0s" is a zero duration
+			return v, true
+		}
+		if redact.IsSensitiveKey(key) {
+			return redact.Mask, false
+		}
+		return redact.String(v), false
+	case nil:
+		return v, true
+	case bool:
+		return v, v == defaults[key]
+	case int:
+		return v, v == 0
+	case float64:
+		return v, v == 0
+	}
+	return value, false
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderVerifySSL(t *testing.T) {
+	var cf Config
+	err := yaml.Unmarshal([]byte("influxes:\n  on:\n    host: http://a\n  off:\n    host: http://b\n    verify_ssl: false\ntests:\n  t:\n    sql: SELECT 1\n"), &cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cf.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rendered struct {
+		Influxes map[string]map[string]interface{} `yaml:"influxes"`
+	}
+	if err := yaml.Unmarshal([]byte(out), &rendered); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := rendered.Influxes["off"]["verify_ssl"]; !ok || v != false {
+		t.Errorf("verify_ssl: false is not rendered:\n%s", out)
+	}
+	if _, ok := rendered.Influxes["on"]["verify_ssl"]; ok {
+		t.Errorf("the default verify_ssl is rendered:\n%s", out)
+	}
+	if strings.Contains(out, "is_template") {
+		t.Errorf("false bools with a false default are rendered:\n%s", out)
+	}
+}
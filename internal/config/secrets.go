@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"github.com/nagylzs/pigflux/internal/redact"
+	"gopkg.in/yaml.v3"
+)
+
+// EncryptedTag marks encrypted values in config files. The value is the base64 encoded age ciphertext.
+const EncryptedTag = "!encrypted"
+
+// Environment variables that give the age identity used for decryption, when --secrets-key-file is not given.
+const (
+	EnvSecretsKeyFile = "PIGFLUX_AGE_KEY_FILE"
+	EnvSecretsKey     = "PIGFLUX_AGE_KEY"
+)
+
+var (
+	secretsKeyFile string
+	identitiesOnce sync.Once
+	identities     []age.Identity
+	identitiesErr  error
+)
+
+// SetSecretsKeyFile sets the age identity file that is used to decrypt !encrypted values. It must be called
+// before loading configs.
+func SetSecretsKeyFile(path string) {
+	secretsKeyFile = path
+}
+
+// SecretIdentities loads the identities for decryption, from the key file or from the environment.
+func SecretIdentities() ([]age.Identity, error) {
+	identitiesOnce.Do(func() {
+		path := secretsKeyFile
+		if path == "" {
+			path = os.Getenv(EnvSecretsKeyFile)
+		}
+		if path != "" {
+			identities, identitiesErr = ReadIdentities(path)
+			return
+		}
+		if key := os.Getenv(EnvSecretsKey); key != "" {
+			identities, identitiesErr = age.ParseIdentities(strings.NewReader(key))
+			if identitiesErr != nil {
+				identitiesErr = fmt.Errorf("invalid %s: %w", EnvSecretsKey, identitiesErr)
+			}
+			return
+		}
+		identitiesErr = fmt.Errorf("no key given for decryption, use --secrets-key-file, %s or %s",
+			EnvSecretsKeyFile, EnvSecretsKey)
+	})
+	return identities, identitiesErr
+}
+
+// ReadIdentities reads age identities from a key file (as created by age-keygen).
+func ReadIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open key file: %w", err)
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse key file %s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// Recipients returns the recipients for encryption. Recipients can be given as age public keys, when none is
+// given then the public keys of the X25519 identities are used.
+func Recipients(publicKeys []string, ids []age.Identity) ([]age.Recipient, error) {
+	result := make([]age.Recipient, 0)
+	for _, pk := range publicKeys {
+		r, err := age.ParseX25519Recipient(pk)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %s: %w", pk, err)
+		}
+		result = append(result, r)
+	}
+	if len(result) == 0 {
+		for _, id := range ids {
+			if x, ok := id.(*age.X25519Identity); ok {
+				result = append(result, x.Recipient())
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no recipients given")
+	}
+	return result, nil
+}
+
+// EncryptValue encrypts a value, and returns it in the form that can be used in config files.
+func EncryptValue(plaintext string, recipients []age.Recipient) (string, error) {
+	buf := &bytes.Buffer{}
+	w, err := age.Encrypt(buf, recipients...)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.WriteString(w, plaintext)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+	return EncryptedTag + " " + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecryptValue decrypts a value. The EncryptedTag prefix is optional.
+func DecryptValue(value string, ids []age.Identity) (string, error) {
+	value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), EncryptedTag))
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("encrypted value is not base64 encoded: %w", err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), ids...)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// decryptSecret decrypts an !encrypted value of a config file, with the identities given for the program.
+func decryptSecret(value string) (string, error) {
+	ids, err := SecretIdentities()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := DecryptValue(value, ids)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt value: %w", err)
+	}
+	redact.AddSecrets(plaintext)
+	return plaintext, nil
+}
+
+// RotateSecrets re-encrypts all !encrypted values in a config file for the given recipients. The file is parsed,
+// the values are replaced in the yaml tree, and the file is written into a temporary file and renamed over the
+// original. Comments and the quoting of the values are kept, but the indentation is normalized. It returns the
+// number of values that were re-encrypted.
+func RotateSecrets(path string, ids []age.Identity, recipients []age.Recipient) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	docs := make([]*yaml.Node, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := &yaml.Node{}
+		err = decoder.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		docs = append(docs, doc)
+	}
+	count := 0
+	for _, doc := range docs {
+		n, err := rotateNode(doc, ids, recipients)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		count += n
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		err = encoder.Encode(doc)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	err = encoder.Close()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	err = replaceFile(path, buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// rotateNode re-encrypts the !encrypted scalars of a node tree, and returns their number.
+func rotateNode(node *yaml.Node, ids []age.Identity, recipients []age.Recipient) (int, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == EncryptedTag {
+		plaintext, err := DecryptValue(node.Value, ids)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: cannot decrypt value: %w", node.Line, err)
+		}
+		encrypted, err := EncryptValue(plaintext, recipients)
+		if err != nil {
+			return 0, err
+		}
+		node.Value = strings.TrimPrefix(encrypted, EncryptedTag+" ")
+		if node.Style == yaml.LiteralStyle || node.Style == yaml.FoldedStyle {
+			node.Style = 0
+		}
+		return 1, nil
+	}
+	count := 0
+	for _, child := range node.Content {
+		n, err := rotateNode(child, ids, recipients)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// replaceFile writes the data into a temporary file next to path, with the permissions of the original file, and
+// renames it over the original, so the file is never partially written.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+	return nil
+}
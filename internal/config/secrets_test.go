@@ -0,0 +1,113 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRotateSecrets(t *testing.T) {
+	oldID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(value string) string {
+		encrypted, err := EncryptValue(value, []age.Recipient{oldID.Recipient()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimPrefix(encrypted, EncryptedTag+" ")
+	}
+	comment := "# an old value: !encrypted QUJDRA=="
+	data := comment + "\n" +
+		"databases:\n" +
+		"  db1:\n" +
+		"    dsn: !encrypted \"" + encrypt("dsn1") + "\"\n" +
+		"    driver: pgx\n" +
+		"  db2:\n" +
+		"    dsn: !encrypted\n" +
+		"      " + encrypt("dsn2") + "\n" +
+		"influxes:\n" +
+		"  i1:\n" +
+		"    password: !encrypted '" + encrypt("pw") + "' # inline comment\n"
+	path := filepath.Join(t.TempDir(), "pigflux.yml")
+	if err := os.WriteFile(path, []byte(data), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := RotateSecrets(path, []age.Identity{oldID}, []age.Recipient{newID.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got %d re-encrypted values, want 3", count)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("file mode is %v, want 0640", info.Mode().Perm())
+	}
+	rotated, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(rotated)
+	for _, want := range []string{comment, "# inline comment", `dsn: !encrypted "`, "password: !encrypted '"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("rotated file does not contain %q:\n%s", want, text)
+		}
+	}
+
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(rotated, doc); err != nil {
+		t.Fatal(err)
+	}
+	values := make([]string, 0)
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Tag == EncryptedTag {
+			if _, err := DecryptValue(node.Value, []age.Identity{oldID}); err == nil {
+				t.Errorf("line %d can still be decrypted with the old key", node.Line)
+			}
+			value, err := DecryptValue(node.Value, []age.Identity{newID})
+			if err != nil {
+				t.Errorf("line %d: %v", node.Line, err)
+			}
+			values = append(values, value)
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(doc)
+	if strings.Join(values, ",") != "dsn1,dsn2,pw" {
+		t.Errorf("got values %v", values)
+	}
+
+	// a value that cannot be decrypted leaves the file unchanged
+	_, err = RotateSecrets(path, []age.Identity{oldID}, []age.Recipient{newID.Recipient()})
+	if err == nil {
+		t.Fatal("rotating with the wrong key should fail")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != text {
+		t.Error("the file is changed after a failed rotation")
+	}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+	if len(matches) > 0 {
+		t.Errorf("temporary files are left: %v", matches)
+	}
+}
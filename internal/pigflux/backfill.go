@@ -0,0 +1,80 @@
+package pigflux
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// BackfillConfig checks that a test can be backfilled, and returns the config to use for the backfill. Only tests
+// of SQL databases can be backfilled, and all of their queries must depend on the window (e.g. use {start} and
+// {end}), otherwise every window would return the same data. StatsD has no timestamps, so the statsd sinks of the
+// test are skipped.
+func BackfillConfig(cf config.Config, testName string) (config.Config, error) {
+	test, ok := cf.Tests[testName]
+	if !ok || test.IsTemplate {
+		return cf, fmt.Errorf("test %s does not exist", testName)
+	}
+	if test.Watermark.Enabled() {
+		return cf, fmt.Errorf("test %s has a watermark, it cannot be backfilled", testName)
+	}
+	for _, dbname := range test.Databases {
+		st, err := cf.SourceType(dbname)
+		if err != nil {
+			return cf, err
+		}
+		if st != config.SourceDatabase {
+			return cf, fmt.Errorf("test %s runs on %s (%s), only tests of SQL databases can be backfilled", testName, dbname, st)
+		}
+	}
+	queries := []string{test.SQL}
+	for _, variant := range test.Variants {
+		queries = append(queries, variant.SQL)
+	}
+	for _, query := range queries {
+		if query != "" && !usesWindow(test, query) {
+			return cf, fmt.Errorf("a query of test %s does not use {start} or {end}, every window would return the same data", testName)
+		}
+	}
+	if len(test.Statsds) == 0 {
+		return cf, nil
+	}
+	slog.Warn(fmt.Sprintf("StatsD has no timestamps, the statsd sinks of test %s are skipped", testName), "statsds", test.Statsds)
+	test.Statsds = nil
+	if !test.HasTargets() {
+		return cf, fmt.Errorf("test %s has no sinks other than statsd, it cannot be backfilled", testName)
+	}
+	result := cf
+	result.Tests = maps.Clone(cf.Tests)
+	result.Tests[testName] = test
+	return result, nil
+}
+
+// usesWindow tells if a query depends on the backfill window: it uses {start} or {end}, a previous_run or
+// pass_start param, or a time param relative to now.
+func usesWindow(test config.Test, query string) bool {
+	if test.SQLTemplate && (strings.Contains(query, ".Pass.Start") || strings.Contains(query, ".PreviousRun")) {
+		return true
+	}
+	for _, m := range queryRef.FindAllStringSubmatch(query, -1) {
+		if m[2] != "" {
+			return true
+		}
+		param, ok := test.Params[m[1]]
+		if !ok {
+			continue
+		}
+		switch param.Type {
+		case config.ParamPreviousRun, config.ParamPassStart:
+			return true
+		case config.ParamTime:
+			if strings.HasPrefix(strings.TrimSpace(param.Value), "now") {
+				return true
+			}
+		}
+	}
+	return false
+}
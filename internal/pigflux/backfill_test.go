@@ -0,0 +1,173 @@
+package pigflux
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func TestBackfillConfig(t *testing.T) {
+	base := config.Config{
+		Databases: map[string]config.Database{"db": {Driver: "sqlite"}},
+		Execs:     map[string]config.Exec{"cmd": {}},
+		Statsds:   map[string]config.Statsd{"sd": {}},
+		Files:     map[string]config.File{"f": {}},
+	}
+	windowed := "SELECT count(*) AS cnt FROM events WHERE ts >= {start} AND ts < {end}"
+	tests := []struct {
+		name string
+		test config.Test
+		err  string
+	}{
+		{"window", config.Test{Databases: []string{"db"}, Files: []string{"f"}, SQL: windowed}, ""},
+		{"previous run param", config.Test{Databases: []string{"db"}, Files: []string{"f"},
+			SQL:    "SELECT count(*) AS cnt FROM events WHERE ts >= {PARAMS[since]}",
+			Params: map[string]config.Param{"since": {Type: config.ParamPreviousRun}}}, ""},
+		{"relative time param", config.Test{Databases: []string{"db"}, Files: []string{"f"},
+			SQL:    "SELECT count(*) AS cnt FROM events WHERE ts >= {PARAMS[since]}",
+			Params: map[string]config.Param{"since": {Type: config.ParamTime, Value: "now-1h"}}}, ""},
+		{"no window", config.Test{Databases: []string{"db"}, Files: []string{"f"}, SQL: "SELECT count(*) AS cnt FROM events"},
+			"does not use {start} or {end}"},
+		{"absolute time param", config.Test{Databases: []string{"db"}, Files: []string{"f"},
+			SQL:    "SELECT count(*) AS cnt FROM events WHERE ts >= {PARAMS[since]}",
+			Params: map[string]config.Param{"since": {Type: config.ParamTime, Value: "2024-01-01"}}}, "does not use {start} or {end}"},
+		{"variant without window", config.Test{Databases: []string{"db"}, Files: []string{"f"}, SQL: windowed,
+			Variants: []config.SQLVariant{{SQL: "SELECT count(*) AS cnt FROM events", MinVersion: "3"}}}, "does not use {start} or {end}"},
+		{"exec source", config.Test{Databases: []string{"cmd"}, Files: []string{"f"}}, "only tests of SQL databases"},
+		{"watermark", config.Test{Databases: []string{"db"}, Files: []string{"f"}, SQL: windowed,
+			Watermark: config.Watermark{Column: "id"}}, "has a watermark"},
+		{"statsd only", config.Test{Databases: []string{"db"}, Statsds: []string{"sd"}, SQL: windowed}, "no sinks other than statsd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := base
+			cf.Tests = map[string]config.Test{"test": tt.test}
+			_, err := BackfillConfig(cf, "test")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// statsd sinks are skipped, the original config is not changed
+	cf := base
+	cf.Tests = map[string]config.Test{"test": {Databases: []string{"db"}, Files: []string{"f"}, Statsds: []string{"sd"}, SQL: windowed}}
+	result, err := BackfillConfig(cf, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Tests["test"].Statsds) != 0 || len(result.Tests["test"].Files) != 1 {
+		t.Errorf("got test %+v, want the statsd sinks skipped", result.Tests["test"])
+	}
+	if len(cf.Tests["test"].Statsds) != 1 {
+		t.Error("the original config is changed")
+	}
+}
+
+func TestBackfillFileDates(t *testing.T) {
+	dir := t.TempDir()
+	cf := config.Config{
+		Files: map[string]config.File{"f": {Format: "line", Path: filepath.Join(dir, "{measurement}-{date}.txt")}},
+		Tests: map[string]config.Test{"test": {Files: []string{"f"}}},
+	}
+	for _, day := range []int{1, 2} {
+		result := TestResult{Measurement: "m", Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{},
+			Time: time.Date(2024, 5, day, 12, 0, 0, 0, time.Local)}
+		errs := &SinkErrors{}
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		SendTestResultsFile(context.Background(), cf, "test", []TestResult{result}, errs, wg)
+		wg.Wait()
+		if err := errs.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"m-2024-05-01.txt", "m-2024-05-02.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("file of the result date: %v", err)
+		}
+	}
+}
+
+func TestBackfillParquetPartitions(t *testing.T) {
+	dir := t.TempDir()
+	cf := config.Config{
+		Parquets: map[string]config.Parquet{"p": {Directory: dir, MaxRows: 1000, MaxAge: time.Hour}},
+		Tests:    map[string]config.Test{"test": {Parquets: []string{"p"}}},
+	}
+	t.Cleanup(FlushParquets)
+	window := func(day int, hour int) TestResult {
+		return TestResult{Measurement: "m", Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{},
+			Time: time.Date(2024, 5, day, hour, 0, 0, 0, time.UTC)}
+	}
+	// windows of the same day are buffered together
+	for _, hour := range []int{1, 2, 3} {
+		if err := sendParquet(cf, []TestResult{window(1, hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := parquetFiles(t, dir); len(files) != 0 {
+		t.Fatalf("got files %v, the rows of the day should still be buffered", files)
+	}
+	// the first window of the next day writes out the previous day
+	if err := sendParquet(cf, []TestResult{window(2, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	files := parquetFiles(t, dir)
+	if len(files) != 1 || !strings.Contains(files[0], "date=2024-05-01") {
+		t.Fatalf("got files %v, want the partition of 2024-05-01", files)
+	}
+	if table := readParquetTable(t, files[0]); table.NumRows() != 3 {
+		t.Errorf("got %d rows, want 3", table.NumRows())
+	}
+	FlushParquets()
+	files = parquetFiles(t, dir)
+	if len(files) != 2 || !strings.Contains(files[1], "date=2024-05-02") {
+		t.Errorf("got files %v, want the partitions of both days", files)
+	}
+}
+
+func TestBackfillWindowWritesParquets(t *testing.T) {
+	dir := t.TempDir()
+	cf := config.Config{
+		Databases: map[string]config.Database{"db": sqliteDatabase(t)},
+		Parquets:  map[string]config.Parquet{"p": {Directory: dir, MaxRows: 1000, MaxAge: time.Hour}},
+		Tests: map[string]config.Test{"test": {
+			Databases: []string{"db"}, Parquets: []string{"p"}, Measurement: "m",
+			SQL: "SELECT 1 AS cnt", Fields: []string{"cnt"},
+		}},
+	}
+	t.Cleanup(FlushParquets)
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	window := Window{Start: start, End: start.Add(time.Hour)}
+	if err := RunBackfillWindow(cf, "test", 0, window); err != nil {
+		t.Fatal(err)
+	}
+	if files := parquetFiles(t, dir); len(files) != 1 {
+		t.Fatalf("got files %v, the rows of the window should be written out", files)
+	}
+
+	// a window whose rows cannot be written fails, so its checkpoint is not stored
+	if err := os.RemoveAll(filepath.Join(dir, "m")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "m"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(filepath.Join(dir, "m")) })
+	if err := RunBackfillWindow(cf, "test", 1, Window{Start: window.End, End: window.End.Add(time.Hour)}); err == nil {
+		t.Error("a failed parquet write is not an error of the window")
+	}
+}
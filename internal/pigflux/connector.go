@@ -12,6 +12,7 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
 )
 
 type IConnV1 struct {
@@ -25,9 +26,10 @@ func ConnectInfluxes(cf config.Config, names []string) []IConnV1 {
 	for _, name := range names {
 		icfg := cf.Influxes[name]
 		conn, err := client.NewHTTPClient(client.HTTPConfig{
-			Addr:     icfg.URL,
-			Username: icfg.Username,
-			Password: icfg.Password,
+			Addr:               icfg.URL,
+			Username:           icfg.Username,
+			Password:           icfg.Password,
+			InsecureSkipVerify: !icfg.VerifySSL,
 		})
 		if err != nil {
 			slog.Error("could not create influx v1 connection", "error", err)
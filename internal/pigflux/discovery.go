@@ -0,0 +1,188 @@
+package pigflux
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// lastDiscovered holds the last successfully discovered database names of each server, keyed by DSN and query.
+// They are used when the discovery fails, so a temporary error does not stop the tests of the databases.
+var lastDiscovered sync.Map
+
+// DiscoverDatabases expands database servers with discovery into the databases found on them. It returns a copy
+// of the config, where the discovered databases are added as "<server>/<database>", and the tests that use the
+// server are run on the discovered databases instead. It should be called at the start of each pass.
+func DiscoverDatabases(cf config.Config) config.Config {
+	discovered := make(map[string][]string)
+	for name, db := range cf.Databases {
+		if !db.Discovery.Enabled() {
+			continue
+		}
+		key := db.DSN + "\x00" + db.Discovery.Query
+		names, err := discoverDatabases(db)
+		if err != nil {
+			last, ok := lastDiscovered.Load(key)
+			if !ok {
+				// Without a list, nothing is run: the SQL of the tests must not run on the server itself.
+				slog.Error("could not discover databases, skipping the tests of the server", "dbname", name, "error", err)
+				discovered[name] = nil
+				continue
+			}
+			names = last.([]string)
+			slog.Error("could not discover databases, using the last discovered ones", "dbname", name, "databases", names, "error", err)
+		} else {
+			lastDiscovered.Store(key, names)
+		}
+		discovered[name] = names
+	}
+	if len(discovered) == 0 {
+		return cf
+	}
+
+	result := cf
+	result.Databases = maps.Clone(cf.Databases)
+	result.Tests = maps.Clone(cf.Tests)
+	for server, names := range discovered {
+		db := cf.Databases[server]
+		for _, name := range names {
+			ddb := db
+			ddb.DSN = discoveredDSN(db.Driver, db.Discovery.DSNTemplate, name)
+			ddb.Discovery = config.Discovery{}
+			ddb.DatabaseName = name
+			ddb.ServerName = server
+			result.Databases[server+"/"+name] = ddb
+		}
+		slog.Debug(fmt.Sprintf("Discovered %d database(s) on %s: %v", len(names), server, names))
+	}
+	for testName, test := range result.Tests {
+		if !slices.ContainsFunc(test.Databases, func(s string) bool { _, ok := discovered[s]; return ok }) {
+			continue
+		}
+		dbnames := make([]string, 0, len(test.Databases))
+		for _, dbname := range test.Databases {
+			names, ok := discovered[dbname]
+			if !ok {
+				dbnames = append(dbnames, dbname)
+				continue
+			}
+			for _, name := range names {
+				dbnames = append(dbnames, dbname+"/"+name)
+			}
+		}
+		test.Databases = dbnames
+		result.Tests[testName] = test
+	}
+	return result
+}
+
+// discoverDatabases runs the listing query of the server, and returns the names that pass the include
+// and exclude filters.
+func discoverDatabases(db config.Database) ([]string, error) {
+	include, err := compileAll(db.Discovery.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileAll(db.Discovery.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open(db.Driver, db.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			slog.Warn("could not close connection", "error", err.Error())
+		}
+	}()
+	rows, err := conn.Query(db.Discovery.Query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			slog.Warn("could not close rows", "error", err.Error())
+		}
+	}()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		if len(include) > 0 && !slices.ContainsFunc(include, func(re *regexp.Regexp) bool { return re.MatchString(name) }) {
+			continue
+		}
+		if slices.ContainsFunc(exclude, func(re *regexp.Regexp) bool { return re.MatchString(name) }) {
+			continue
+		}
+		result = append(result, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+// discoveredDSN replaces {database} in the DSN template with the name, escaped for the position of the placeholder.
+// URLs (and mysql DSNs) get path or query escaping, the values of key=value DSNs are quoted when needed.
+func discoveredDSN(driver string, tpl string, name string) string {
+	if strings.Contains(tpl, "://") || driver == "mysql" || strings.HasPrefix(tpl, "file:") {
+		result := ""
+		for i, part := range strings.SplitAfter(tpl, "?") {
+			if i == 0 {
+				result += strings.ReplaceAll(part, "{database}", url.PathEscape(name))
+			} else {
+				result += strings.ReplaceAll(part, "{database}", url.QueryEscape(name))
+			}
+		}
+		return result
+	}
+	var value string
+	switch driver {
+	case "pgx":
+		// libpq style: 'value' with backslash escapes
+		quoted := "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name) + "'"
+		tpl = strings.ReplaceAll(tpl, "'{database}'", quoted)
+		value = name
+		if name == "" || strings.ContainsAny(name, ` '\`+"\t\n") {
+			value = quoted
+		}
+	case "sqlserver":
+		// ADO style: {value} with doubled closing braces
+		value = name
+		if strings.ContainsAny(name, ";{}'\"=") || strings.TrimSpace(name) != name {
+			value = "{" + strings.ReplaceAll(name, "}", "}}") + "}"
+		}
+	default:
+		value = name
+	}
+	return strings.ReplaceAll(tpl, "{database}", value)
+}
+
+func compileAll(exprs []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package pigflux
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func TestDiscoveredDSN(t *testing.T) {
+	tests := []struct {
+		driver string
+		tpl    string
+		name   string
+		want   string
+	}{
+		{"pgx", "postgres://u:p@h:5432/{database}?sslmode=disable", "app_1", "postgres://u:p@h:5432/app_1?sslmode=disable"},
+		{"pgx", "postgres://u:p@h:5432/{database}", "a b/c?d", "postgres://u:p@h:5432/a%20b%2Fc%3Fd"},
+		{"pgx", "host=h user=u dbname={database}", "app", "host=h user=u dbname=app"},
+		{"pgx", "host=h user=u dbname={database}", "it's me", `host=h user=u dbname='it\'s me'`},
+		{"pgx", "host=h user=u dbname='{database}'", `a\b`, `host=h user=u dbname='a\\b'`},
+		{"mysql", "u:p@tcp(h:3306)/{database}?parseTime=true", "a/b", "u:p@tcp(h:3306)/a%2Fb?parseTime=true"},
+		{"sqlserver", "sqlserver://u:p@h:1433?database={database}", "a&b=c", "sqlserver://u:p@h:1433?database=a%26b%3Dc"},
+		{"sqlserver", "server=h;user id=u;database={database}", "a;b", "server=h;user id=u;database={a;b}"},
+		{"sqlserver", "server=h;user id=u;database={database}", "plain", "server=h;user id=u;database=plain"},
+	}
+	for _, tt := range tests {
+		if got := discoveredDSN(tt.driver, tt.tpl, tt.name); got != tt.want {
+			t.Errorf("%s %q with %q: got %q, want %q", tt.driver, tt.tpl, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoveryKeepsLastList(t *testing.T) {
+	db := sqliteDatabase(t, "CREATE TABLE dbs (name TEXT)", "INSERT INTO dbs VALUES ('a'), ('b')")
+	db.Discovery = config.Discovery{Query: "SELECT name FROM dbs", DSNTemplate: "file:/tmp/{database}.db"}
+	cf := config.Config{
+		Databases: map[string]config.Database{"srv": db},
+		Tests:     map[string]config.Test{"test": {Databases: []string{"srv"}}},
+	}
+	discovered := DiscoverDatabases(cf)
+	if got := discovered.Tests["test"].Databases; !slices.Equal(got, []string{"srv/a", "srv/b"}) {
+		t.Fatalf("got databases %v", got)
+	}
+
+	conn, err := sql.Open(db.Driver, db.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("DROP TABLE dbs"); err != nil {
+		t.Fatal(err)
+	}
+	discovered = DiscoverDatabases(cf)
+	if got := discovered.Tests["test"].Databases; !slices.Equal(got, []string{"srv/a", "srv/b"}) {
+		t.Errorf("got databases %v, want the last discovered ones", got)
+	}
+	if _, ok := discovered.Databases["srv/a"]; !ok {
+		t.Error("the last discovered databases are not added")
+	}
+
+}
+
+func TestDiscoveryFailsFirst(t *testing.T) {
+	// without a successful discovery, the server is left out of the tests
+	srv := sqliteDatabase(t)
+	srv.Discovery = config.Discovery{Query: "SELECT name FROM dbs", DSNTemplate: "file:/tmp/{database}.db"}
+	plain := sqliteDatabase(t)
+	cf := config.Config{
+		Databases: map[string]config.Database{"srv": srv, "plain": plain},
+		Tests:     map[string]config.Test{"test": {Databases: []string{"srv", "plain"}}},
+	}
+	discovered := DiscoverDatabases(cf)
+	if got := discovered.Tests["test"].Databases; !slices.Equal(got, []string{"plain"}) {
+		t.Errorf("got databases %v, want only plain", got)
+	}
+}
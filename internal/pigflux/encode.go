@@ -0,0 +1,140 @@
+package pigflux
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+type jsonPoint struct {
+	Time        time.Time              `json:"time"`
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+}
+
+// encodeJSON serializes a test result into a single JSON object.
+func encodeJSON(result TestResult, ts time.Time) ([]byte, error) {
+	return json.Marshal(jsonPoint{
+		Time:        ts,
+		Measurement: result.Measurement,
+		Tags:        result.Tags,
+		Fields:      result.Fields,
+	})
+}
+
+// encodeLineProtocol serializes a test result into InfluxDB line protocol, with nanosecond precision.
+func encodeLineProtocol(result TestResult, ts time.Time) (string, error) {
+	pt, err := client.NewPoint(result.Measurement, result.Tags, result.Fields, ts)
+	if err != nil {
+		return "", err
+	}
+	return pt.String(), nil
+}
+
+var csvHeader = []string{"time", "measurement", "field", "value", "tags"}
+
+// encodeCSV serializes a test result into CSV records, one record for each field. The tags are
+// added to every record as a JSON object. The columns are given by csvHeader.
+func encodeCSV(result TestResult, ts time.Time) ([][]string, error) {
+	tags, err := json.Marshal(result.Tags)
+	if err != nil {
+		return nil, err
+	}
+	records := make([][]string, 0, len(result.Fields))
+	for _, field := range slices.Sorted(maps.Keys(result.Fields)) {
+		value := result.Fields[field]
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		records = append(records, []string{
+			ts.Format(time.RFC3339Nano),
+			result.Measurement,
+			field,
+			fmt.Sprintf("%v", value),
+			string(tags),
+		})
+	}
+	return records, nil
+}
+
+type jsonSchemaField struct {
+	Field    string            `json:"field,omitempty"`
+	Type     string            `json:"type"`
+	Optional bool              `json:"optional"`
+	Name     string            `json:"name,omitempty"`
+	Fields   []jsonSchemaField `json:"fields,omitempty"`
+	Keys     *jsonSchemaField  `json:"keys,omitempty"`
+	Values   *jsonSchemaField  `json:"values,omitempty"`
+}
+
+type jsonSchemaEnvelope struct {
+	Schema  jsonSchemaField        `json:"schema"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+func jsonSchemaType(value interface{}) string {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "int64"
+	case float32, float64:
+		return "double"
+	case bool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// encodeJSONSchema serializes a test result into a JSON envelope with an embedded schema, in the format
+// used by the Kafka Connect JsonConverter (with schemas enabled). The time is given in milliseconds.
+func encodeJSONSchema(result TestResult, ts time.Time) ([]byte, error) {
+	fieldSchemas := make([]jsonSchemaField, 0, len(result.Fields))
+	fields := make(map[string]interface{}, len(result.Fields))
+	for _, field := range slices.Sorted(maps.Keys(result.Fields)) {
+		value := result.Fields[field]
+		typ := jsonSchemaType(value)
+		if typ == "string" && value != nil {
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			} else if _, ok := value.(string); !ok {
+				value = fmt.Sprintf("%v", value)
+			}
+		}
+		fieldSchemas = append(fieldSchemas, jsonSchemaField{Field: field, Type: typ, Optional: true})
+		fields[field] = value
+	}
+	return json.Marshal(jsonSchemaEnvelope{
+		Schema: jsonSchemaField{
+			Type: "struct",
+			Name: result.Measurement,
+			Fields: []jsonSchemaField{
+				{Field: "time", Type: "int64", Name: "org.apache.kafka.connect.data.Timestamp"},
+				{Field: "measurement", Type: "string"},
+				{Field: "tags", Type: "map", Keys: &jsonSchemaField{Type: "string"}, Values: &jsonSchemaField{Type: "string"}},
+				{Field: "fields", Type: "struct", Fields: fieldSchemas},
+			},
+		},
+		Payload: map[string]interface{}{
+			"time":        ts.UnixMilli(),
+			"measurement": result.Measurement,
+			"tags":        result.Tags,
+			"fields":      fields,
+		},
+	})
+}
+
+// encodeResult serializes a test result with the given encoding: line, json or json_schema.
+func encodeResult(encoding string, result TestResult, ts time.Time) ([]byte, error) {
+	switch encoding {
+	case "line":
+		line, err := encodeLineProtocol(result, ts)
+		return []byte(line), err
+	case "json_schema":
+		return encodeJSONSchema(result, ts)
+	}
+	return encodeJSON(result, ts)
+}
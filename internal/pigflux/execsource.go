@@ -0,0 +1,100 @@
+package pigflux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/influxdata/line-protocol/v2/lineprotocol"
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// fetchExec runs the command, and parses its standard output. The output can be a JSON object, a JSON array
+// of objects, a sequence of JSON objects, or influx line protocol.
+func fetchExec(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+	ecfg := cf.Execs[dbname]
+	ctx, cancel := context.WithTimeout(context.Background(), ecfg.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ecfg.Command, ecfg.Args...)
+	cmd.Dir = ecfg.Dir
+	// children of a killed command can keep its output open, do not wait for them after the timeout
+	cmd.WaitDelay = time.Second
+	cmd.Env = os.Environ()
+	for key, value := range ecfg.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("command %s timed out after %v", ecfg.Command, ecfg.Timeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 1024 {
+			msg = msg[:1024] + "..."
+		}
+		return nil, fmt.Errorf("command %s failed: %w: %s", ecfg.Command, err, msg)
+	}
+
+	format := ecfg.Format
+	if format == "auto" {
+		format = "line"
+		trimmed := bytes.TrimSpace(stdout.Bytes())
+		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+			format = "json"
+		}
+	}
+	if format == "json" {
+		return parseJSONOutput(test, stdout.Bytes())
+	}
+	return parseLineProtocolOutput(test, stdout.Bytes())
+}
+
+// parseLineProtocolOutput parses influx line protocol. The tags and fields of each line are
+// treated as columns, so fields that are not listed in the test will become tags. The measurement
+// name and the timestamp are ignored.
+func parseLineProtocolOutput(test config.Test, data []byte) ([]FetchResult, error) {
+	dec := lineprotocol.NewDecoderWithBytes(data)
+	result := make([]FetchResult, 0)
+	for dec.Next() {
+		row := make(map[string]interface{})
+		_, err := dec.Measurement()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse line protocol output: %w", err)
+		}
+		for {
+			key, value, err := dec.NextTag()
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse line protocol output: %w", err)
+			}
+			if key == nil {
+				break
+			}
+			row[string(key)] = string(value)
+		}
+		for {
+			key, value, err := dec.NextField()
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse line protocol output: %w", err)
+			}
+			if key == nil {
+				break
+			}
+			row[string(key)] = value.Interface()
+		}
+		fr, err := rowFetchResult(test, row)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fr)
+	}
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package pigflux
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func fetchShell(t *testing.T, script string, format string, timeout time.Duration, test config.Test) ([]FetchResult, error) {
+	t.Helper()
+	cf := config.Config{Execs: map[string]config.Exec{"cmd": {
+		Command: "sh", Args: []string{"-c", script}, Env: map[string]string{"PIGFLUX_TEST": "3"},
+		Timeout: timeout, Format: format,
+	}}}
+	return fetchExec(cf, "cmd", test)
+}
+
+func TestExecFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		format string
+	}{
+		{"auto json array", `echo '[{"host":"a","v":1},{"host":"b","v":2}]'`, "auto"},
+		{"json sequence", `printf '{"host":"a","v":1}\n{"host":"b","v":2}\n'`, "json"},
+		{"auto line protocol", `printf 'm,host=a v=1i 1700000000000000000\nm,host=b v=2i\n'`, "auto"},
+		{"line protocol", `echo 'm,host=a v=1i'; echo 'm,host=b v=2i'`, "line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := fetchShell(t, tt.script, tt.format, 5*time.Second, config.Test{Fields: []string{"v"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 2 {
+				t.Fatalf("got %d results, want 2", len(results))
+			}
+			for i, host := range []string{"a", "b"} {
+				if r := results[i]; r.Fields["v"] != int64(i+1) || r.Tags["host"] != host || len(r.Tags) != 1 {
+					t.Errorf("unexpected result %+v", r)
+				}
+			}
+		})
+	}
+}
+
+func TestExecLineProtocolColumns(t *testing.T) {
+	// fields that are not listed in the test become tags, the environment is passed to the command
+	results, err := fetchShell(t, `echo "m,host=a v=1.5,n=$PIGFLUX_TEST"`, "auto", 5*time.Second, config.Test{Fields: []string{"v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Fields["v"] != 1.5 || results[0].Tags["n"] != "3" || results[0].Tags["host"] != "a" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	_, err := fetchShell(t, "echo broken >&2; exit 3", "auto", 5*time.Second, config.Test{})
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("got %v, want an error with the exit status and the stderr", err)
+	}
+	_, err = fetchShell(t, "echo m v=1", "json", 5*time.Second, config.Test{})
+	if err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Errorf("got %v, want a JSON error", err)
+	}
+	_, err = fetchShell(t, "echo 'm v='", "line", 5*time.Second, config.Test{})
+	if err == nil || !strings.Contains(err.Error(), "line protocol") {
+		t.Errorf("got %v, want a line protocol error", err)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	started := time.Now()
+	// the shell is not the last command, so the sleep is its child, that keeps the output open
+	_, err := fetchShell(t, "sleep 10; echo m v=1", "auto", 200*time.Millisecond, config.Test{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("the command was stopped after %v", elapsed)
+	}
+}
@@ -0,0 +1,288 @@
+package pigflux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// fileLock serializes writes and rotations, because multiple tests and file targets may share the same files.
+var fileLock sync.Mutex
+
+func SendTestResultsFile(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	test := cf.Tests[name]
+	wg2 := &sync.WaitGroup{}
+	wg2.Add(len(test.Files))
+	for _, fname := range test.Files {
+		go SendTestResultsFileConn(ctx, cf, name, results, fname, errs, wg2)
+	}
+	wg2.Wait()
+}
+
+func SendTestResultsFileConn(ctx context.Context, cf config.Config, name string, results []TestResult, fname string, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	fcfg := cf.Files[fname]
+	hostName, _ := os.Hostname()
+
+	// Group results by their target path, so each file is opened only once.
+	paths := make([]string, 0)
+	grouped := make(map[string][]TestResult)
+	patterns := make(map[string]string)
+	for _, result := range results {
+		// date variables come from the time of the result, so backfilled results go to the files of their dates
+		ts := result.timestamp()
+		vars := map[string]string{
+			"measurement": result.Measurement,
+			"test":        name,
+			"date":        ts.Format("2006-01-02"),
+			"year":        ts.Format("2006"),
+			"month":       ts.Format("01"),
+			"day":         ts.Format("02"),
+			"host":        hostName,
+		}
+		path := expandTemplate(fcfg.Path, vars)
+		if _, ok := grouped[path]; !ok {
+			paths = append(paths, path)
+			patterns[path] = filePattern(fcfg.Path, vars)
+		}
+		grouped[path] = append(grouped[path], result)
+	}
+
+	for _, path := range paths {
+		data, err := encodeFileResults(fcfg.Format, grouped[path], resultsTime(grouped[path]))
+		if err != nil {
+			errs.Error("could not encode test results", "type", "file", "name", fname, "path", path, "error", err)
+			continue
+		}
+		err = appendToFile(fcfg, path, patterns[path], data)
+		if err != nil {
+			errs.Error("could not write test results", "type", "file", "name", fname, "path", path, "error", err)
+		}
+	}
+}
+
+func encodeFileResults(format string, results []TestResult, ts time.Time) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if format == "csv" {
+		w := csv.NewWriter(buf)
+		for _, result := range results {
+			records, err := encodeCSV(result, ts)
+			if err != nil {
+				return nil, err
+			}
+			err = w.WriteAll(records)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	}
+	for _, result := range results {
+		if format == "line" {
+			line, err := encodeLineProtocol(result, ts)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(line)
+		} else {
+			line, err := encodeJSON(result, ts)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(line)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// dateVars are the variables of file paths that change over time.
+var dateVars = map[string]bool{"date": true, "year": true, "month": true, "day": true}
+
+// filePattern returns a glob pattern that matches the files of a path template, with the dated variables
+// replaced with wildcards, e.g. the files of all days for a {date} path.
+func filePattern(tpl string, vars map[string]string) string {
+	pattern := ""
+	for _, t := range SplitIntoTokens(tpl) {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			name := t[1 : len(t)-1]
+			if dateVars[name] {
+				pattern += "*"
+				continue
+			}
+			if value, ok := vars[name]; ok {
+				pattern += globEscape(value)
+				continue
+			}
+		}
+		pattern += globEscape(t)
+	}
+	return pattern
+}
+
+var globReplacer = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+
+func globEscape(s string) string {
+	if runtime.GOOS == "windows" {
+		// backslash is the path separator, and these characters cannot be used in file names anyway
+		return s
+	}
+	return globReplacer.Replace(s)
+}
+
+// appendToFile appends data to the file, rotating it when needed. The pattern matches all files of the path
+// template, old files are removed when a new file is started.
+func appendToFile(cfg config.File, path string, pattern string, data []byte) error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	existed := fileExists(path)
+	rotated, err := rotateFile(cfg, path, int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("cannot rotate %s: %w", path, err)
+	}
+	if cfg.Retain > 0 && (rotated || !existed) {
+		err = removeOldFiles(cfg.Retain, pattern, path)
+		if err != nil {
+			return fmt.Errorf("cannot remove old files of %s: %w", path, err)
+		}
+	}
+	fd, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := fd.Stat()
+	if err == nil && st.Size() == 0 && cfg.Format == "csv" {
+		w := csv.NewWriter(fd)
+		err = w.Write(csvHeader)
+		if err == nil {
+			w.Flush()
+			err = w.Error()
+		}
+	}
+	if err == nil {
+		_, err = fd.Write(data)
+	}
+	return errors.Join(err, fd.Close())
+}
+
+// rotateFile renames the file to path.TIMESTAMP if it would grow over max_size, or if it was last
+// written in a previous max_age period. The rotated file is compressed when needed. It tells whether the file
+// was rotated.
+func rotateFile(cfg config.File, path string, incoming int64) (bool, error) {
+	st, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	rotate := cfg.MaxSize > 0 && st.Size() > 0 && st.Size()+incoming > cfg.MaxSize
+	if cfg.MaxAge > 0 && !st.ModTime().Truncate(cfg.MaxAge).Equal(now.Truncate(cfg.MaxAge)) {
+		rotate = true
+	}
+	if !rotate {
+		return false, nil
+	}
+
+	rotated := path + "." + now.Format("20060102T150405")
+	for idx := 1; fileExists(rotated) || fileExists(rotated+".gz"); idx++ {
+		rotated = fmt.Sprintf("%s.%s-%d", path, now.Format("20060102T150405"), idx)
+	}
+	err = os.Rename(path, rotated)
+	if err != nil {
+		return false, err
+	}
+	if cfg.Compress {
+		err = gzipFile(rotated)
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// removeOldFiles keeps the newest retain files of the pattern (and their rotated files), other than the current
+// file, and removes the others.
+func removeOldFiles(retain int, pattern string, current string) error {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	rotated, err := filepath.Glob(pattern + ".*")
+	if err != nil {
+		return err
+	}
+	type oldFile struct {
+		path    string
+		modTime time.Time
+	}
+	old := make([]oldFile, 0)
+	for _, path := range slices.Concat(files, rotated) {
+		if path == current || slices.ContainsFunc(old, func(f oldFile) bool { return f.path == path }) {
+			continue
+		}
+		st, err := os.Stat(path)
+		if err != nil || st.IsDir() {
+			continue
+		}
+		old = append(old, oldFile{path: path, modTime: st.ModTime()})
+	}
+	slices.SortFunc(old, func(a, b oldFile) int {
+		if c := a.modTime.Compare(b.modTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.path, b.path)
+	})
+	for len(old) > retain {
+		err = os.Remove(old[0].path)
+		if err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Join(err, src.Close())
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	err = errors.Join(err, zw.Close(), dst.Close(), src.Close())
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,88 @@
+package pigflux
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func TestFilePattern(t *testing.T) {
+	vars := map[string]string{"measurement": "m[1]", "test": "t", "date": "2026-01-02", "year": "2026"}
+	got := filePattern("/data/{measurement}/{year}/{date}-{test}.jsonl", vars)
+	want := `/data/m\[1]/*/*-t.jsonl`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFileRetainDated(t *testing.T) {
+	dir := t.TempDir()
+	// files of earlier days, the oldest first
+	old := []string{"2026-01-01.jsonl", "2026-01-02.jsonl.20260102T235959.gz", "2026-01-02.jsonl", "2026-01-03.jsonl"}
+	start := time.Now().Add(-time.Hour)
+	for i, name := range old {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		ts := start.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, ts, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a file of another measurement is not touched
+	other := filepath.Join(dir, "other.txt")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.File{Format: "jsonl", Path: filepath.Join(dir, "{date}.jsonl"), Retain: 2}
+	vars := map[string]string{"date": "2026-01-04"}
+	path := expandTemplate(cfg.Path, vars)
+	if err := appendToFile(cfg, path, filePattern(cfg.Path, vars), []byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"2026-01-02.jsonl", "2026-01-03.jsonl", "2026-01-04.jsonl", "other.txt"}
+	if !slices.Equal(names, want) {
+		t.Errorf("got files %v, want %v", names, want)
+	}
+
+	// appending to the current file does not remove anything
+	if err := appendToFile(cfg, path, filePattern(cfg.Path, vars), []byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != len(want) {
+		t.Errorf("got %d files after append, want %d", len(entries), len(want))
+	}
+}
+
+func TestFileRetainRotated(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.File{Format: "jsonl", Path: filepath.Join(dir, "out.jsonl"), MaxSize: 4, Retain: 2}
+	for i := 0; i < 5; i++ {
+		if err := appendToFile(cfg, cfg.Path, filePattern(cfg.Path, nil), []byte("{}\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, err := filepath.Glob(cfg.Path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Errorf("got rotated files %v, want 2", rotated)
+	}
+}
@@ -0,0 +1,57 @@
+package pigflux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// fetchHTTP sends a request to the HTTP endpoint, and converts the JSON response into result rows,
+// using the json_root and json_paths of the test.
+func fetchHTTP(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+	hcfg := cf.HTTPSources[dbname]
+	cl, err := newHTTPClient(hcfg.TLS, hcfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create http client %s: %w", dbname, err)
+	}
+	defer cl.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), hcfg.Timeout)
+	defer cancel()
+	var body io.Reader
+	if hcfg.Body != "" {
+		body = strings.NewReader(hcfg.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, hcfg.Method, hcfg.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for key, value := range hcfg.Headers {
+		req.Header.Set(key, value)
+	}
+	setHTTPAuth(req, hcfg.Username, hcfg.Password, hcfg.BearerToken)
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, msg)
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	err = dec.Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse JSON response: %w", err)
+	}
+	return jsonFetchResults(test, doc)
+}
@@ -0,0 +1,160 @@
+package pigflux
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func httpSourceServer(t *testing.T, status int, response string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept"); got != "application/json" {
+			t.Errorf("Accept is %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization is %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fetchHTTPStub(t *testing.T, status int, response string, test config.Test) ([]FetchResult, error) {
+	t.Helper()
+	srv := httpSourceServer(t, status, response)
+	cf := config.Config{HTTPSources: map[string]config.HTTPSource{"api": {
+		URL: srv.URL, Method: "GET", BearerToken: "secret", Timeout: 5 * time.Second,
+	}}}
+	return fetchHTTP(cf, "api", test)
+}
+
+func TestHTTPJSONRoot(t *testing.T) {
+	results, err := fetchHTTPStub(t, http.StatusOK, `{"data":{"items":[
+{"name":"a","size":1,"meta":null},
+{"name":"b","size":2.5,"meta":{"x":1}}]}}`, config.Test{JSONRoot: "$.data.items", Fields: []string{"size"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want the 2 items", len(results))
+	}
+	// null values are not tags
+	if r := results[0]; r.Fields["size"] != int64(1) || r.Tags["name"] != "a" || len(r.Tags) != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	// nested objects are kept as JSON text
+	if r := results[1]; r.Fields["size"] != 2.5 || r.Tags["name"] != "b" || r.Tags["meta"] != `{"x":1}` {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestHTTPJSONPaths(t *testing.T) {
+	test := config.Test{
+		JSONRoot: `$['data']["hosts"][*]`,
+		JSONPaths: map[string]string{
+			"host":    "$.host",
+			"last":    "cpu.load[-1]",
+			"first":   "$.cpu['load'][0]",
+			"missing": "$.nope",
+		},
+		Fields: []string{"last"},
+	}
+	results, err := fetchHTTPStub(t, http.StatusOK, `{"data":{"hosts":[
+{"host":"h1","cpu":{"load":[0.1,0.5,0.9]}},
+{"host":"h2","cpu":{"load":[0.2]}}]}}`, test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want the 2 hosts", len(results))
+	}
+	// columns without a match are left out
+	if r := results[0]; r.Fields["last"] != 0.9 || r.Tags["host"] != "h1" || r.Tags["first"] != "0.1" || len(r.Tags) != 2 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r := results[1]; r.Fields["last"] != 0.2 || r.Tags["host"] != "h2" || r.Tags["first"] != "0.2" {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	_, err := fetchHTTPStub(t, http.StatusServiceUnavailable, "overloaded", config.Test{})
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "overloaded") {
+		t.Errorf("got %v, want an error with the status and the body", err)
+	}
+	_, err = fetchHTTPStub(t, http.StatusOK, `{"values":[1,2]}`, config.Test{JSONRoot: "$.values"})
+	if err == nil || !strings.Contains(err.Error(), "must be objects") {
+		t.Errorf("got %v, want an error for non-object rows", err)
+	}
+	_, err = fetchHTTPStub(t, http.StatusOK, `[{"a":1}]`, config.Test{Fields: []string{"b"}})
+	if err == nil || !strings.Contains(err.Error(), "missing fields") {
+		t.Errorf("got %v, want an error for a missing field", err)
+	}
+	_, err = fetchHTTPStub(t, http.StatusOK, `{"a":`, config.Test{})
+	if err == nil {
+		t.Error("expected an error for an invalid response")
+	}
+}
+
+func TestHTTPScalarRows(t *testing.T) {
+	// non-object rows can be used with json_paths
+	results, err := fetchHTTPStub(t, http.StatusOK, `{"values":[1,2]}`,
+		config.Test{JSONRoot: "$.values", JSONPaths: map[string]string{"value": "$"}, Fields: []string{"value"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Fields["value"] != int64(1) || results[1].Fields["value"] != int64(2) {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestEvalJSONPath(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{"a":{"x":1,"y":2},"b":[10,20,30],"c d":"e"}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want []interface{}
+		err  string
+	}{
+		{"$.a.x", []interface{}{1.0}, ""},
+		{"a.y", []interface{}{2.0}, ""},
+		{"$.a.*", []interface{}{1.0, 2.0}, ""},
+		{"$.b[*]", []interface{}{10.0, 20.0, 30.0}, ""},
+		{"$.b[-1]", []interface{}{30.0}, ""},
+		{"$.b[3]", []interface{}{}, ""},
+		{"$.b[-4]", []interface{}{}, ""},
+		{`$["c d"]`, []interface{}{"e"}, ""},
+		{"$['a']['x']", []interface{}{1.0}, ""},
+		{"$.a.x.z", []interface{}{}, ""},
+		{"$.b[0", nil, "unterminated"},
+		{"$..a", nil, "empty key"},
+	}
+	for _, tt := range tests {
+		got, err := evalJSONPath(doc, tt.path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.path, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package pigflux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/InfluxCommunity/influxdb3-go/v2/influxdb3"
+	influxdb2 "github.com/influxdata/influxdb-client-go"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/nagylzs/set"
+)
+
+// Columns that are returned by influx queries, but they are not used as tags (unless they are listed in fields).
+var influxIgnoredColumns = set.FromArray([]string{"time"})
+var influx2IgnoredColumns = set.FromArray([]string{"result", "table", "_start", "_stop", "_time"})
+
+func queryContext(test config.Test) (context.Context, context.CancelFunc) {
+	if test.QueryTimeout > 0 {
+		return context.WithTimeout(context.Background(), test.QueryTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// influxFetchResult creates a FetchResult from a row returned by an influx query. Ignored columns are
+// dropped unless they are listed in the fields of the test.
+func influxFetchResult(test config.Test, row map[string]interface{}, ignored *set.Set[string]) (FetchResult, error) {
+	fs := set.FromArray(test.Fields)
+	columns := make([]string, 0, len(row))
+	values := make([]interface{}, 0, len(row))
+	for _, col := range slices.Sorted(maps.Keys(row)) {
+		if ignored.Contains(col) && !fs.Contains(col) {
+			continue
+		}
+		val := row[col]
+		if n, ok := val.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				val = i
+			} else if f, err := n.Float64(); err == nil {
+				val = f
+			} else {
+				val = n.String()
+			}
+		}
+		columns = append(columns, col)
+		values = append(values, val)
+	}
+	err := checkColumns(columns)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	return newFetchResult(test, columns, values)
+}
+
+// fetchInflux runs an InfluxQL query. Each row of each returned series becomes a FetchResult, and
+// the tags of the series (e.g. from GROUP BY) are added to all rows.
+func fetchInflux(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+	icfg := cf.Influxes[dbname]
+	conn, err := client.NewHTTPClient(client.HTTPConfig{
+		Addr:               icfg.URL,
+		Username:           icfg.Username,
+		Password:           icfg.Password,
+		InsecureSkipVerify: !icfg.VerifySSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to influx %s: %w", dbname, err)
+	}
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			slog.Warn("could not close connection", "dbname", dbname, "error", err.Error())
+		}
+	}()
+
+	ctx, cancel := queryContext(test)
+	defer cancel()
+	resp, err := conn.QueryCtx(ctx, client.NewQuery(test.SQL, icfg.Database, ""))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	result := make([]FetchResult, 0)
+	for _, res := range resp.Results {
+		if res.Err != "" {
+			return nil, fmt.Errorf("%s", res.Err)
+		}
+		for _, series := range res.Series {
+			for _, values := range series.Values {
+				row := make(map[string]interface{}, len(series.Columns)+len(series.Tags))
+				for name, value := range series.Tags {
+					row[name] = value
+				}
+				for i, col := range series.Columns {
+					row[col] = values[i]
+				}
+				fr, err := influxFetchResult(test, row, influxIgnoredColumns)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, fr)
+			}
+		}
+	}
+	return result, nil
+}
+
+// fetchInflux2 runs a Flux query. Each record becomes a FetchResult. Use pivot() to get multiple
+// fields into the same record.
+func fetchInflux2(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+	icfg := cf.Influxes2[dbname]
+	cl := influxdb2.NewClient(icfg.Url, icfg.Token)
+	defer cl.Close()
+
+	ctx, cancel := queryContext(test)
+	defer cancel()
+	rows, err := cl.QueryAPI(icfg.Org).Query(ctx, test.SQL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			slog.Warn("could not close rows", "dbname", dbname, "error", err.Error())
+		}
+	}()
+	result := make([]FetchResult, 0)
+	for rows.Next() {
+		fr, err := influxFetchResult(test, rows.Record().Values(), influx2IgnoredColumns)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fr)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return result, nil
+}
+
+// fetchInflux3 runs an SQL query over Flight. Each row becomes a FetchResult.
+func fetchInflux3(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+	icfg := cf.Influxes3[dbname]
+	cl, err := influxdb3.NewFromConnectionString(icfg.Url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to influx3 %s: %w", dbname, err)
+	}
+	defer func() {
+		err := cl.Close()
+		if err != nil {
+			slog.Warn("could not close connection", "dbname", dbname, "error", err.Error())
+		}
+	}()
+
+	ctx, cancel := queryContext(test)
+	defer cancel()
+	it, err := cl.Query(ctx, test.SQL)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]FetchResult, 0)
+	for it.Next() {
+		fr, err := influxFetchResult(test, it.Value(), influxIgnoredColumns)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fr)
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package pigflux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// influxQueryResponse has two series, the second one has a NULL value in a sparse row.
+const influxQueryResponse = `{"results":[{"statement_id":0,"series":[
+{"name":"cpu","tags":{"host":"h1"},"columns":["time","usage","region"],"values":[["2026-01-02T03:04:05Z",1.5,"eu"]]},
+{"name":"cpu","tags":{"host":"h2"},"columns":["time","usage","region"],"values":[["2026-01-02T03:04:05Z",2,null]]}
+]}]}`
+
+func influxServer(t *testing.T, tls bool) *httptest.Server {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query" || r.URL.Query().Get("db") != "telegraf" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(influxQueryResponse))
+	})
+	var srv *httptest.Server
+	if tls {
+		srv = httptest.NewTLSServer(handler)
+	} else {
+		srv = httptest.NewServer(handler)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchInflux(t *testing.T) {
+	srv := influxServer(t, false)
+	cf := config.Config{Influxes: map[string]config.Influx{"in": {URL: srv.URL, Database: "telegraf", VerifySSL: true}}}
+	results, err := fetchInflux(cf, "in", config.Test{SQL: "SELECT usage, region FROM cpu GROUP BY host", Fields: []string{"usage"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if r := results[0]; r.Fields["usage"] != 1.5 || r.Tags["host"] != "h1" || r.Tags["region"] != "eu" || len(r.Tags) != 2 {
+		t.Errorf("unexpected first result %+v", r)
+	}
+	// the time column is dropped, and the NULL region is not a tag
+	if r := results[1]; r.Fields["usage"] != int64(2) || r.Tags["host"] != "h2" || len(r.Tags) != 1 {
+		t.Errorf("unexpected second result %+v", r)
+	}
+}
+
+func TestFetchInfluxVerifySSL(t *testing.T) {
+	srv := influxServer(t, true)
+	test := config.Test{SQL: "SELECT usage FROM cpu", Fields: []string{"usage"}}
+	cf := config.Config{Influxes: map[string]config.Influx{"in": {URL: srv.URL, Database: "telegraf", VerifySSL: true}}}
+	if _, err := fetchInflux(cf, "in", test); err == nil {
+		t.Error("expected a certificate error with verify_ssl")
+	}
+	cf.Influxes["in"] = config.Influx{URL: srv.URL, Database: "telegraf", VerifySSL: false}
+	if _, err := fetchInflux(cf, "in", test); err != nil {
+		t.Errorf("the certificate should not be verified without verify_ssl: %v", err)
+	}
+}
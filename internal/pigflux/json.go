@@ -0,0 +1,203 @@
+package pigflux
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// jsonValue converts a decoded JSON value into a field/tag value. Nested objects and arrays are kept as JSON text.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		js, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(js)
+	}
+	return value
+}
+
+// rowFetchResult creates a FetchResult from a row given as a map.
+func rowFetchResult(test config.Test, row map[string]interface{}) (FetchResult, error) {
+	columns := slices.Sorted(maps.Keys(row))
+	values := make([]interface{}, len(columns))
+	for i, col := range columns {
+		values[i] = jsonValue(row[col])
+	}
+	err := checkColumns(columns)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	return newFetchResult(test, columns, values)
+}
+
+// parseJSONOutput parses a JSON document, or a sequence of JSON documents.
+func parseJSONOutput(test config.Test, data []byte) ([]FetchResult, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	result := make([]FetchResult, 0)
+	for {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse JSON output: %w", err)
+		}
+		frs, err := jsonFetchResults(test, doc)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, frs...)
+	}
+	return result, nil
+}
+
+// jsonFetchResults converts a JSON document into result rows.
+//
+// The rows are selected with the json_root path of the test. When it is not given, the document itself is used.
+// When the selection is a single array, then its items are the rows, otherwise each selected value is a row.
+//
+// The columns of a row are selected with the json_paths of the test, which maps column names to paths
+// relative to the row. Columns without a match are left out. When json_paths is not given, the rows must be
+// objects, and their keys are the columns.
+func jsonFetchResults(test config.Test, doc interface{}) ([]FetchResult, error) {
+	rows := []interface{}{doc}
+	if test.JSONRoot != "" {
+		var err error
+		rows, err = evalJSONPath(doc, test.JSONRoot)
+		if err != nil {
+			return nil, fmt.Errorf("json_root: %w", err)
+		}
+	}
+	if len(rows) == 1 {
+		if items, ok := rows[0].([]interface{}); ok {
+			rows = items
+		}
+	}
+
+	result := make([]FetchResult, 0, len(rows))
+	for _, row := range rows {
+		columns := make(map[string]interface{})
+		if len(test.JSONPaths) > 0 {
+			for col, path := range test.JSONPaths {
+				matches, err := evalJSONPath(row, path)
+				if err != nil {
+					return nil, fmt.Errorf("json_paths[%s]: %w", col, err)
+				}
+				if len(matches) > 0 {
+					columns[col] = matches[0]
+				}
+			}
+		} else {
+			obj, ok := row.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("result rows must be objects, got %T (use json_paths to select values)", row)
+			}
+			columns = obj
+		}
+		fr, err := rowFetchResult(test, columns)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fr)
+	}
+	return result, nil
+}
+
+// evalJSONPath evaluates a simple JSONPath expression, and returns all matching values. The supported
+// syntax is: $ (the root), .name, ['name'], [index], [*] and .* (all items of an array or object).
+func evalJSONPath(doc interface{}, path string) ([]interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	current := []interface{}{doc}
+	for _, step := range steps {
+		next := make([]interface{}, 0)
+		for _, node := range current {
+			switch v := node.(type) {
+			case map[string]interface{}:
+				if step == "*" {
+					for _, key := range slices.Sorted(maps.Keys(v)) {
+						next = append(next, v[key])
+					}
+				} else if value, ok := v[step]; ok {
+					next = append(next, value)
+				}
+			case []interface{}:
+				if step == "*" {
+					next = append(next, v...)
+				} else if idx, err := strconv.Atoi(step); err == nil {
+					if idx < 0 {
+						idx += len(v)
+					}
+					if idx >= 0 && idx < len(v) {
+						next = append(next, v[idx])
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// parseJSONPath splits a JSONPath expression into steps (keys, indexes and *).
+func parseJSONPath(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	steps := make([]string, 0)
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in JSONPath")
+			}
+			steps = append(steps, path[:end])
+			path = path[end:]
+		case '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in JSONPath")
+			}
+			key := strings.TrimSpace(path[1:end])
+			if len(key) >= 2 && (key[0] == '\'' || key[0] == '"') && key[len(key)-1] == key[0] {
+				key = key[1 : len(key)-1]
+			}
+			steps = append(steps, key)
+			path = path[end+1:]
+		default:
+			// Allow paths without the leading $. like "data.items"
+			if len(steps) > 0 {
+				return nil, fmt.Errorf("unexpected character %q in JSONPath", path[0])
+			}
+			path = "." + path
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,141 @@
+package pigflux
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"sync"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+type I2ConnKafka struct {
+	Cfg    config.Kafka
+	Name   string
+	Writer *kafka.Writer
+}
+
+func ConnectKafkas(cf config.Config, names []string) []I2ConnKafka {
+	conns := make([]I2ConnKafka, 0)
+	for _, name := range names {
+		kcfg := cf.Kafkas[name]
+		w, err := newKafkaWriter(kcfg)
+		if err != nil {
+			slog.Error("could not create kafka writer", "name", name, "error", err)
+			continue
+		}
+		conns = append(conns, I2ConnKafka{Cfg: kcfg, Name: name, Writer: w})
+	}
+	return conns
+}
+
+func newKafkaWriter(cfg config.Kafka) (*kafka.Writer, error) {
+	transport := &kafka.Transport{ClientID: "pigflux"}
+	if cfg.UseTLS {
+		tlsCfg, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		transport.TLS = tlsCfg
+	}
+	if cfg.SASL.Mechanism != "" {
+		var mechanism sasl.Mechanism
+		var err error
+		switch cfg.SASL.Mechanism {
+		case "scram-sha-256":
+			mechanism, err = scram.Mechanism(scram.SHA256, cfg.SASL.Username, cfg.SASL.Password)
+		case "scram-sha-512":
+			mechanism, err = scram.Mechanism(scram.SHA512, cfg.SASL.Username, cfg.SASL.Password)
+		default:
+			mechanism = plain.Mechanism{Username: cfg.SASL.Username, Password: cfg.SASL.Password}
+		}
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+	var acks kafka.RequiredAcks
+	err := acks.UnmarshalText([]byte(cfg.Acks))
+	if err != nil {
+		return nil, err
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: acks,
+		WriteTimeout: cfg.Timeout,
+		Transport:    transport,
+	}
+	switch cfg.Compression {
+	case "gzip":
+		w.Compression = kafka.Gzip
+	case "snappy":
+		w.Compression = kafka.Snappy
+	case "lz4":
+		w.Compression = kafka.Lz4
+	case "zstd":
+		w.Compression = kafka.Zstd
+	}
+	return w, nil
+}
+
+func CloseKafkas(conns []I2ConnKafka) {
+	for _, cl := range conns {
+		err := cl.Writer.Close()
+		if err != nil {
+			slog.Error("could not close kafka writer", "name", cl.Name, "error", err)
+		}
+	}
+}
+
+func SendTestResultsKafka(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	test := cf.Tests[name]
+	conns := ConnectKafkas(cf, test.Kafkas)
+	errs.connected("kafka", test.Kafkas, len(conns))
+	defer CloseKafkas(conns)
+
+	wg2 := &sync.WaitGroup{}
+	wg2.Add(len(conns))
+	for _, conn := range conns {
+		go SendTestResultsKafkaConn(ctx, cf, name, results, conn, errs, wg2)
+	}
+	wg2.Wait()
+}
+
+func SendTestResultsKafkaConn(ctx context.Context, cf config.Config, name string, results []TestResult, conn I2ConnKafka, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	now := resultsTime(results)
+	msgs := make([]kafka.Message, 0, len(results))
+	for _, result := range results {
+		value, err := encodeResult(conn.Cfg.Encoding, result, now)
+		if err != nil {
+			errs.Error("could not encode test result", "type", "kafka", "name", conn.Name, "measurement", result.Measurement, "error", err)
+			continue
+		}
+		vars := resultVars(name, result)
+		msg := kafka.Message{
+			Topic: expandTemplate(conn.Cfg.Topic, vars),
+			Value: value,
+			Time:  now,
+		}
+		if conn.Cfg.Key != "" {
+			msg.Key = []byte(expandTemplate(conn.Cfg.Key, vars))
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	err := conn.Writer.WriteMessages(ctx, msgs...)
+	if err != nil {
+		errs.Error("could not write messages", "type", "kafka", "name", conn.Name, "error", err)
+	}
+}
@@ -0,0 +1,193 @@
+package pigflux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	metadataAPI "github.com/segmentio/kafka-go/protocol/metadata"
+	produceAPI "github.com/segmentio/kafka-go/protocol/produce"
+)
+
+type kafkaRecord struct {
+	Topic string
+	Acks  int16
+	Key   string
+	Value string
+}
+
+// fakeKafka is an in-process kafka.RoundTripper, that answers metadata requests with a single partition for
+// every topic, and records the produced messages.
+type fakeKafka struct {
+	mu      sync.Mutex
+	records []kafkaRecord
+}
+
+func (f *fakeKafka) RoundTrip(_ context.Context, _ net.Addr, req kafka.Request) (kafka.Response, error) {
+	switch r := req.(type) {
+	case *metadataAPI.Request:
+		resp := &metadataAPI.Response{Brokers: []metadataAPI.ResponseBroker{{NodeID: 1, Host: "localhost", Port: 9092}}}
+		for _, topic := range r.TopicNames {
+			resp.Topics = append(resp.Topics, metadataAPI.ResponseTopic{
+				Name:       topic,
+				Partitions: []metadataAPI.ResponsePartition{{PartitionIndex: 0, LeaderID: 1}},
+			})
+		}
+		return resp, nil
+	case *produceAPI.Request:
+		resp := &produceAPI.Response{}
+		for _, topic := range r.Topics {
+			rt := produceAPI.ResponseTopic{Topic: topic.Topic}
+			for _, partition := range topic.Partitions {
+				for {
+					rec, err := partition.RecordSet.Records.ReadRecord()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						return nil, err
+					}
+					key, _ := protocol.ReadAll(rec.Key)
+					value, _ := protocol.ReadAll(rec.Value)
+					f.mu.Lock()
+					f.records = append(f.records, kafkaRecord{Topic: topic.Topic, Acks: r.Acks, Key: string(key), Value: string(value)})
+					f.mu.Unlock()
+				}
+				rt.Partitions = append(rt.Partitions, produceAPI.ResponsePartition{Partition: partition.Partition})
+			}
+			resp.Topics = append(resp.Topics, rt)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("unexpected request %T", req)
+}
+
+func sendKafka(t *testing.T, kcfg config.Kafka, results []TestResult) []kafkaRecord {
+	t.Helper()
+	w, err := newKafkaWriter(kcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeKafka{}
+	w.Transport = fake
+	w.BatchTimeout = 10 * time.Millisecond
+	conn := I2ConnKafka{Cfg: kcfg, Name: "kf", Writer: w}
+	defer CloseKafkas([]I2ConnKafka{conn})
+
+	errs := &SinkErrors{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	SendTestResultsKafkaConn(context.Background(), config.Config{}, "test", results, conn, errs, wg)
+	wg.Wait()
+	if err := errs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return fake.records
+}
+
+var kafkaResults = []TestResult{{
+	Measurement: "db",
+	Fields:      map[string]interface{}{"size": int64(42)},
+	Tags:        map[string]string{"database_name": "db1"},
+	Time:        time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+}}
+
+func TestKafkaEncodings(t *testing.T) {
+	ts := kafkaResults[0].Time
+	tests := []struct {
+		encoding string
+		check    func(t *testing.T, value string)
+	}{
+		{"line", func(t *testing.T, value string) {
+			want := fmt.Sprintf("db,database_name=db1 size=42i %d", ts.UnixNano())
+			if value != want {
+				t.Errorf("got %q, want %q", value, want)
+			}
+		}},
+		{"json", func(t *testing.T, value string) {
+			point := jsonPoint{}
+			if err := json.Unmarshal([]byte(value), &point); err != nil {
+				t.Fatal(err)
+			}
+			if point.Measurement != "db" || !point.Time.Equal(ts) || point.Tags["database_name"] != "db1" || point.Fields["size"] != 42.0 {
+				t.Errorf("unexpected point %+v", point)
+			}
+		}},
+		{"json_schema", func(t *testing.T, value string) {
+			envelope := struct {
+				Schema  jsonSchemaField        `json:"schema"`
+				Payload map[string]interface{} `json:"payload"`
+			}{}
+			if err := json.Unmarshal([]byte(value), &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if envelope.Schema.Name != "db" || len(envelope.Schema.Fields) != 4 {
+				t.Errorf("unexpected schema %+v", envelope.Schema)
+			}
+			fields := envelope.Schema.Fields[3].Fields
+			if len(fields) != 1 || fields[0].Field != "size" || fields[0].Type != "int64" {
+				t.Errorf("unexpected field schemas %+v", fields)
+			}
+			if envelope.Payload["time"] != float64(ts.UnixMilli()) {
+				t.Errorf("time is %v, want %d", envelope.Payload["time"], ts.UnixMilli())
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			records := sendKafka(t, config.Kafka{
+				Brokers:  []string{"localhost:9092"},
+				Topic:    "pigflux.{measurement}",
+				Key:      "{tags.database_name}",
+				Encoding: tt.encoding,
+				Acks:     "all",
+				Timeout:  5 * time.Second,
+			}, kafkaResults)
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			if records[0].Topic != "pigflux.db" || records[0].Key != "db1" {
+				t.Errorf("got topic %q and key %q, want pigflux.db and db1", records[0].Topic, records[0].Key)
+			}
+			tt.check(t, records[0].Value)
+		})
+	}
+}
+
+func TestKafkaAcks(t *testing.T) {
+	for acks, want := range map[string]int16{"none": 0, "one": 1, "all": -1} {
+		t.Run(acks, func(t *testing.T) {
+			records := sendKafka(t, config.Kafka{
+				Brokers:  []string{"localhost:9092"},
+				Topic:    "pigflux",
+				Encoding: "line",
+				Acks:     acks,
+				Timeout:  5 * time.Second,
+			}, kafkaResults)
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			if records[0].Acks != want {
+				t.Errorf("produce request acks is %d, want %d", records[0].Acks, want)
+			}
+			if records[0].Key != "" {
+				t.Errorf("key is %q, want no key", records[0].Key)
+			}
+			if !strings.HasPrefix(records[0].Value, "db,") {
+				t.Errorf("unexpected value %q", records[0].Value)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package pigflux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// I2ConnMqtt is a long-lived MQTT connection. Messages are kept in an offline buffer while the broker
+// is not reachable, and they are published after reconnecting.
+type I2ConnMqtt struct {
+	Cfg    config.Mqtt
+	Name   string
+	Client mqtt.Client
+
+	mu       sync.Mutex
+	flushing sync.Mutex
+	buffer   []mqttMessage
+	dropped  int // number of messages dropped from the head of the buffer, so a flush can tell if its head is gone
+}
+
+// mqttConns are shared between test runs, they are closed by Shutdown.
+var mqttConns = make(map[config.Mqtt]*I2ConnMqtt)
+var mqttLock sync.Mutex
+
+func ConnectMqtts(cf config.Config, names []string) []*I2ConnMqtt {
+	mqttLock.Lock()
+	defer mqttLock.Unlock()
+	conns := make([]*I2ConnMqtt, 0)
+	for _, name := range names {
+		mcfg := cf.Mqtts[name]
+		conn, ok := mqttConns[mcfg]
+		if !ok {
+			var err error
+			conn, err = newMqttConn(name, mcfg)
+			if err != nil {
+				slog.Error("could not create mqtt client", "name", name, "error", err)
+				continue
+			}
+			mqttConns[mcfg] = conn
+		}
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func newMqttConn(name string, cfg config.Mqtt) (*I2ConnMqtt, error) {
+	tlsCfg, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	conn := &I2ConnMqtt{Cfg: cfg, Name: name}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(cfg.Timeout).
+		SetWriteTimeout(cfg.Timeout).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info(fmt.Sprintf("Connected to mqtt broker %s", cfg.Broker), "name", name)
+			go conn.flushBuffer()
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("mqtt connection lost", "name", name, "error", err)
+		})
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
+	conn.Client = mqtt.NewClient(opts)
+	// With ConnectRetry, the client keeps connecting in the background, so we do not fail here.
+	token := conn.Client.Connect()
+	if token.WaitTimeout(cfg.Timeout) && token.Error() != nil {
+		slog.Warn("could not connect to mqtt broker, messages will be buffered", "name", name, "error", token.Error())
+	}
+	return conn, nil
+}
+
+// CloseMqtts disconnects all MQTT clients. Buffered messages are published before disconnecting when the
+// broker is reachable, otherwise they are lost.
+func CloseMqtts() {
+	mqttLock.Lock()
+	defer mqttLock.Unlock()
+	for key, conn := range mqttConns {
+		if conn.Client.IsConnectionOpen() {
+			conn.flushBuffer()
+		}
+		conn.mu.Lock()
+		if len(conn.buffer) > 0 {
+			slog.Error(fmt.Sprintf("Could not publish %d buffered mqtt message(s), they are lost", len(conn.buffer)),
+				"type", "mqtt", "name", conn.Name, "broker", conn.Cfg.Broker)
+		}
+		conn.mu.Unlock()
+		conn.Client.Disconnect(250)
+		delete(mqttConns, key)
+	}
+}
+
+func SendTestResultsMqtt(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	test := cf.Tests[name]
+	conns := ConnectMqtts(cf, test.Mqtts)
+	errs.connected("mqtt", test.Mqtts, len(conns))
+
+	wg2 := &sync.WaitGroup{}
+	wg2.Add(len(conns))
+	for _, conn := range conns {
+		go SendTestResultsMqttConn(ctx, cf, name, results, conn, errs, wg2)
+	}
+	wg2.Wait()
+}
+
+func SendTestResultsMqttConn(ctx context.Context, cf config.Config, name string, results []TestResult, conn *I2ConnMqtt, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	now := resultsTime(results)
+	msgs := make([]mqttMessage, 0, len(results))
+	for _, result := range results {
+		payload, err := encodeResult(conn.Cfg.Format, result, now)
+		if err != nil {
+			errs.Error("could not encode test result", "type", "mqtt", "name", conn.Name, "measurement", result.Measurement, "error", err)
+			continue
+		}
+		msgs = append(msgs, mqttMessage{Topic: expandTemplate(conn.Cfg.Topic, resultVars(name, result)), Payload: payload})
+	}
+	// Messages always go through the buffer, so the order is kept while older messages are waiting.
+	conn.bufferMessages(msgs)
+	if conn.Client.IsConnectionOpen() {
+		conn.flushBuffer()
+	}
+	// buffered messages are sent later (or lost when the buffer overflows), so they are not accepted yet
+	conn.mu.Lock()
+	buffered := len(conn.buffer)
+	conn.mu.Unlock()
+	if buffered > 0 {
+		errs.Error(fmt.Sprintf("%d mqtt message(s) are kept in the offline buffer, they are not sent yet", buffered),
+			"name", conn.Name)
+	}
+}
+
+func (conn *I2ConnMqtt) publish(msg mqttMessage) error {
+	token := conn.Client.Publish(msg.Topic, conn.Cfg.QoS, conn.Cfg.Retain, msg.Payload)
+	if !token.WaitTimeout(conn.Cfg.Timeout) {
+		return fmt.Errorf("timeout publishing to %s", msg.Topic)
+	}
+	return token.Error()
+}
+
+// bufferMessages appends messages to the offline buffer. When the buffer is full, the oldest messages are dropped.
+func (conn *I2ConnMqtt) bufferMessages(msgs []mqttMessage) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.buffer = append(conn.buffer, msgs...)
+	if over := len(conn.buffer) - conn.Cfg.OfflineBuffer; over > 0 {
+		slog.Warn(fmt.Sprintf("mqtt offline buffer is full, dropping %d message(s)", over), "name", conn.Name)
+		conn.buffer = conn.buffer[over:]
+		conn.dropped += over
+	}
+}
+
+// flushBuffer publishes buffered messages, until the buffer is empty or publishing fails.
+func (conn *I2ConnMqtt) flushBuffer() {
+	conn.flushing.Lock()
+	defer conn.flushing.Unlock()
+	for {
+		conn.mu.Lock()
+		if len(conn.buffer) == 0 {
+			conn.mu.Unlock()
+			return
+		}
+		msg := conn.buffer[0]
+		dropped := conn.dropped
+		conn.mu.Unlock()
+
+		err := conn.publish(msg)
+		if err != nil {
+			slog.Warn("could not publish message, keeping it in the offline buffer", "type", "mqtt", "name", conn.Name, "error", err)
+			return
+		}
+		conn.mu.Lock()
+		// when the buffer overflowed while publishing, the published message was dropped from the head already
+		if conn.dropped == dropped && len(conn.buffer) > 0 {
+			conn.buffer = conn.buffer[1:]
+		}
+		conn.mu.Unlock()
+	}
+}
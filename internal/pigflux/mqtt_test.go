@@ -0,0 +1,224 @@
+package pigflux
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// mqttBroker is an embedded MQTT broker, that records the messages published to pigflux/#.
+type mqttBroker struct {
+	server   *mochi.Server
+	address  string
+	mu       sync.Mutex
+	received []mqttMessage
+}
+
+func startMqttBroker(t *testing.T, address string) *mqttBroker {
+	t.Helper()
+	b := &mqttBroker{server: mochi.New(&mochi.Options{InlineClient: true})}
+	b.server.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := b.server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: address})
+	if err := b.server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	b.address = tcp.Address()
+	err := b.server.Subscribe("pigflux/#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		b.mu.Lock()
+		b.received = append(b.received, mqttMessage{Topic: pk.TopicName, Payload: pk.Payload})
+		b.mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = b.server.Serve() }()
+	return b
+}
+
+// wait waits until count messages are received, and returns them.
+func (b *mqttBroker) wait(t *testing.T, count int) []mqttMessage {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		b.mu.Lock()
+		received := slices.Clone(b.received)
+		b.mu.Unlock()
+		if len(received) >= count || time.Now().After(deadline) {
+			if len(received) != count {
+				t.Fatalf("got %d messages, want %d", len(received), count)
+			}
+			return received
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func mqttConfig(broker *mqttBroker, format string) config.Config {
+	return config.Config{
+		Mqtts: map[string]config.Mqtt{"mq": {
+			Broker:        "tcp://" + broker.address,
+			Topic:         "pigflux/{measurement}",
+			QoS:           1,
+			Format:        format,
+			ClientID:      "pigflux-test-" + format,
+			OfflineBuffer: 100,
+			Timeout:       2 * time.Second,
+		}},
+		Tests: map[string]config.Test{"test": {Mqtts: []string{"mq"}}},
+	}
+}
+
+func sendMqtt(cf config.Config, results []TestResult) error {
+	errs := &SinkErrors{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	SendTestResultsMqtt(context.Background(), cf, "test", results, errs, wg)
+	wg.Wait()
+	return errs.Err()
+}
+
+var mqttResults = []TestResult{
+	{Measurement: "m1", Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{"t": "a"}, Time: time.Unix(1700000000, 0)},
+	{Measurement: "m2", Fields: map[string]interface{}{"f": 2}, Tags: map[string]string{"t": "b"}, Time: time.Unix(1700000000, 0)},
+}
+
+func TestMqttPublish(t *testing.T) {
+	broker := startMqttBroker(t, "127.0.0.1:0")
+	defer broker.server.Close()
+	defer CloseMqtts()
+
+	if err := sendMqtt(mqttConfig(broker, "json"), mqttResults); err != nil {
+		t.Fatal(err)
+	}
+	received := broker.wait(t, 2)
+	for i, msg := range received {
+		want := mqttResults[i].Measurement
+		if msg.Topic != "pigflux/"+want {
+			t.Errorf("topic is %s, want pigflux/%s", msg.Topic, want)
+		}
+		point := jsonPoint{}
+		if err := json.Unmarshal(msg.Payload, &point); err != nil || point.Measurement != want {
+			t.Errorf("unexpected payload %s: %v", msg.Payload, err)
+		}
+	}
+
+	if err := sendMqtt(mqttConfig(broker, "line"), mqttResults[:1]); err != nil {
+		t.Fatal(err)
+	}
+	received = broker.wait(t, 3)
+	if want := "m1,t=a f=1i 1700000000000000000"; string(received[2].Payload) != want {
+		t.Errorf("got %q, want %q", received[2].Payload, want)
+	}
+}
+
+func TestMqttOfflineBuffer(t *testing.T) {
+	broker := startMqttBroker(t, "127.0.0.1:0")
+	address := broker.address
+	defer CloseMqtts()
+	cf := mqttConfig(broker, "json")
+
+	if err := sendMqtt(cf, mqttResults[:1]); err != nil {
+		t.Fatal(err)
+	}
+	broker.wait(t, 1)
+
+	// while the broker is down, messages are kept in the buffer
+	_ = broker.server.Close()
+	time.Sleep(200 * time.Millisecond)
+	if err := sendMqtt(cf, mqttResults); err == nil {
+		t.Error("buffered messages should not be reported as sent")
+	}
+	conns := ConnectMqtts(cf, []string{"mq"})
+	conns[0].mu.Lock()
+	buffered := len(conns[0].buffer)
+	conns[0].mu.Unlock()
+	if buffered != 2 {
+		t.Fatalf("got %d buffered messages, want 2", buffered)
+	}
+
+	// they are published in order after reconnecting
+	broker = startMqttBroker(t, address)
+	defer broker.server.Close()
+	received := broker.wait(t, 2)
+	if !strings.Contains(string(received[0].Payload), `"m1"`) || !strings.Contains(string(received[1].Payload), `"m2"`) {
+		t.Errorf("got messages %s and %s, want m1 and m2 in order", received[0].Payload, received[1].Payload)
+	}
+}
+
+func TestMqttBufferLimit(t *testing.T) {
+	conn := &I2ConnMqtt{Cfg: config.Mqtt{OfflineBuffer: 2}, Name: "mq"}
+	conn.bufferMessages([]mqttMessage{{Topic: "a"}, {Topic: "b"}, {Topic: "c"}})
+	if len(conn.buffer) != 2 || conn.buffer[0].Topic != "b" || conn.buffer[1].Topic != "c" {
+		t.Errorf("got buffer %v, want the newest 2 messages", conn.buffer)
+	}
+}
+
+// fakeMqttClient records the published topics, and calls onPublish before completing a publish.
+type fakeMqttClient struct {
+	mqtt.Client
+	published []string
+	onPublish func(topic string)
+}
+
+type doneToken struct{ mqtt.Token }
+
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Error() error                   { return nil }
+
+func (c *fakeMqttClient) Publish(topic string, _ byte, _ bool, _ interface{}) mqtt.Token {
+	c.published = append(c.published, topic)
+	if c.onPublish != nil {
+		c.onPublish(topic)
+	}
+	return doneToken{}
+}
+
+func TestMqttFlushOverflow(t *testing.T) {
+	client := &fakeMqttClient{}
+	conn := &I2ConnMqtt{Cfg: config.Mqtt{OfflineBuffer: 2, Timeout: time.Second}, Name: "mq", Client: client}
+	conn.bufferMessages([]mqttMessage{{Topic: "a"}, {Topic: "b"}})
+	// the buffer is full, a new message drops "a" while it is being published
+	client.onPublish = func(topic string) {
+		if topic == "a" {
+			conn.bufferMessages([]mqttMessage{{Topic: "c"}})
+		}
+	}
+	conn.flushBuffer()
+	if !slices.Equal(client.published, []string{"a", "b", "c"}) {
+		t.Errorf("got published %v, want every message once", client.published)
+	}
+	if len(conn.buffer) != 0 {
+		t.Errorf("got buffer %v, want empty", conn.buffer)
+	}
+}
+
+func TestMqttCloseFlushes(t *testing.T) {
+	broker := startMqttBroker(t, "127.0.0.1:0")
+	defer broker.server.Close()
+	cf := mqttConfig(broker, "json")
+	conns := ConnectMqtts(cf, []string{"mq"})
+	deadline := time.Now().Add(5 * time.Second)
+	for !conns[0].Client.IsConnectionOpen() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	// messages that are still in the buffer are published before disconnecting
+	conns[0].bufferMessages([]mqttMessage{{Topic: "pigflux/a", Payload: []byte("1")}, {Topic: "pigflux/b", Payload: []byte("2")}})
+	CloseMqtts()
+	broker.wait(t, 2)
+}
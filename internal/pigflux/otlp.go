@@ -0,0 +1,177 @@
+package pigflux
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/nagylzs/pigflux/internal/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"google.golang.org/grpc/credentials"
+)
+
+type I2ConnOtlp struct {
+	Cfg      config.Otlp
+	Name     string
+	Exporter sdkmetric.Exporter
+}
+
+func ConnectOtlps(ctx context.Context, cf config.Config, names []string) []I2ConnOtlp {
+	conns := make([]I2ConnOtlp, 0)
+	for _, name := range names {
+		ocfg := cf.Otlps[name]
+		exporter, err := newOtlpExporter(ctx, ocfg)
+		if err != nil {
+			slog.Error("could not create otlp exporter", "name", name, "error", err)
+			continue
+		}
+		conns = append(conns, I2ConnOtlp{Cfg: ocfg, Name: name, Exporter: exporter})
+	}
+	return conns
+}
+
+func newOtlpExporter(ctx context.Context, cfg config.Otlp) (sdkmetric.Exporter, error) {
+	tlsCfg, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Protocol == "grpc" {
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(cfg.Endpoint),
+			otlpmetricgrpc.WithTimeout(cfg.Timeout),
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		} else if tlsCfg != nil {
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.Headers))
+		}
+		if cfg.Compression == "gzip" {
+			opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	}
+	opts := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(cfg.Endpoint),
+		otlpmetrichttp.WithTimeout(cfg.Timeout),
+	}
+	if cfg.URLPath != "" {
+		opts = append(opts, otlpmetrichttp.WithURLPath(cfg.URLPath))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	} else if tlsCfg != nil {
+		opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsCfg))
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+	}
+	if cfg.Compression == "gzip" {
+		opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	} else {
+		opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.NoCompression))
+	}
+	return otlpmetrichttp.New(ctx, opts...)
+}
+
+func CloseOtlps(ctx context.Context, conns []I2ConnOtlp) {
+	for _, cl := range conns {
+		err := cl.Exporter.Shutdown(ctx)
+		if err != nil {
+			slog.Error("could not close otlp exporter", "name", cl.Name, "error", err)
+		}
+	}
+}
+
+func SendTestResultsOtlp(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	test := cf.Tests[name]
+	conns := ConnectOtlps(ctx, cf, test.Otlps)
+	errs.connected("otlp", test.Otlps, len(conns))
+	defer CloseOtlps(ctx, conns)
+
+	wg2 := &sync.WaitGroup{}
+	wg2.Add(len(conns))
+	for _, conn := range conns {
+		go SendTestResultsOtlpConn(ctx, cf, name, results, conn, errs, wg2)
+	}
+	wg2.Wait()
+}
+
+func SendTestResultsOtlpConn(ctx context.Context, cf config.Config, name string, results []TestResult, conn I2ConnOtlp, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	rm := otlpResourceMetrics(conn.Cfg, results, resultsTime(results))
+	err := conn.Exporter.Export(ctx, rm)
+	if err != nil {
+		errs.Error("could not export metrics", "type", "otlp", "name", conn.Name, "error", err)
+	}
+}
+
+// otlpResourceMetrics converts test results into gauges. Each numeric field becomes a data point of the
+// metric named after the measurement and the field, and tags become data point attributes.
+func otlpResourceMetrics(cfg config.Otlp, results []TestResult, now time.Time) *metricdata.ResourceMetrics {
+	hostName := cfg.HostName
+	if hostName == "" {
+		hostName, _ = os.Hostname()
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", cfg.ServiceName),
+		attribute.String("host.name", hostName),
+	}
+	for _, key := range slices.Sorted(maps.Keys(cfg.ResourceAttributes)) {
+		attrs = append(attrs, attribute.String(key, cfg.ResourceAttributes[key]))
+	}
+
+	points := make(map[string][]metricdata.DataPoint[float64])
+	for _, result := range results {
+		tags := make([]attribute.KeyValue, 0, len(result.Tags))
+		for key, value := range result.Tags {
+			tags = append(tags, attribute.String(key, value))
+		}
+		set := attribute.NewSet(tags...)
+		for field, value := range result.Fields {
+			v, ok := toFloat64(value)
+			if !ok {
+				slog.Debug("skipping non-numeric field for otlp", "measurement", result.Measurement, "field", field)
+				continue
+			}
+			metric := expandTemplate(cfg.MetricName, map[string]string{
+				"measurement": result.Measurement,
+				"field":       field,
+			})
+			points[metric] = append(points[metric], metricdata.DataPoint[float64]{
+				Attributes: set,
+				Time:       now,
+				Value:      v,
+			})
+		}
+	}
+
+	metrics := make([]metricdata.Metrics, 0, len(points))
+	for _, metric := range slices.Sorted(maps.Keys(points)) {
+		metrics = append(metrics, metricdata.Metrics{
+			Name: metric,
+			Data: metricdata.Gauge[float64]{DataPoints: points[metric]},
+		})
+	}
+	return &metricdata.ResourceMetrics{
+		Resource: resource.NewSchemaless(attrs...),
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Scope:   instrumentation.Scope{Name: "github.com/nagylzs/pigflux", Version: version.LocalVersion()},
+			Metrics: metrics,
+		}},
+	}
+}
@@ -0,0 +1,222 @@
+package pigflux
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpReceived is a request received by a test OTLP receiver.
+type otlpReceived struct {
+	Request     *colmetricpb.ExportMetricsServiceRequest
+	Compression string
+	Header      string
+}
+
+var otlpResults = []TestResult{{
+	Measurement: "db",
+	Fields:      map[string]interface{}{"size": int64(42), "ratio": 0.5, "name": "text"},
+	Tags:        map[string]string{"database_name": "db1"},
+}}
+
+func sendOtlp(t *testing.T, cfg config.Otlp) {
+	t.Helper()
+	cfg.Insecure = true
+	cfg.Timeout = 5 * time.Second
+	cfg.MetricName = "pf.{measurement}.{field}"
+	cfg.ServiceName = "pigflux-test"
+	cfg.HostName = "host1"
+	cfg.ResourceAttributes = map[string]string{"env": "test"}
+	cfg.Headers = map[string]string{"x-api-key": "key1"}
+	cf := config.Config{
+		Otlps: map[string]config.Otlp{"ot": cfg},
+		Tests: map[string]config.Test{"test": {Otlps: []string{"ot"}}},
+	}
+	errs := &SinkErrors{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	SendTestResultsOtlp(context.Background(), cf, "test", otlpResults, errs, wg)
+	wg.Wait()
+	if err := errs.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkOtlpRequest(t *testing.T, got otlpReceived, compression string) {
+	t.Helper()
+	if got.Compression != compression {
+		t.Errorf("compression is %q, want %q", got.Compression, compression)
+	}
+	if got.Header != "key1" {
+		t.Errorf("x-api-key header is %q, want key1", got.Header)
+	}
+	rms := got.Request.GetResourceMetrics()
+	if len(rms) != 1 {
+		t.Fatalf("got %d resource metrics, want 1", len(rms))
+	}
+	resourceAttrs := otlpAttrs(rms[0].GetResource().GetAttributes())
+	for key, want := range map[string]string{"service.name": "pigflux-test", "host.name": "host1", "env": "test"} {
+		if resourceAttrs[key] != want {
+			t.Errorf("resource attribute %s is %q, want %q", key, resourceAttrs[key], want)
+		}
+	}
+	values := make(map[string]float64)
+	for _, sm := range rms[0].GetScopeMetrics() {
+		for _, m := range sm.GetMetrics() {
+			for _, dp := range m.GetGauge().GetDataPoints() {
+				values[m.GetName()] = dp.GetAsDouble()
+				if attrs := otlpAttrs(dp.GetAttributes()); attrs["database_name"] != "db1" {
+					t.Errorf("data point attributes of %s are %v, want database_name=db1", m.GetName(), attrs)
+				}
+			}
+		}
+	}
+	want := map[string]float64{"pf.db.size": 42, "pf.db.ratio": 0.5}
+	if len(values) != len(want) {
+		t.Errorf("got metrics %v, want %v", values, want)
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("metric %s is %v, want %v", name, values[name], value)
+		}
+	}
+}
+
+func otlpAttrs(kvs []*commonpb.KeyValue) map[string]string {
+	result := make(map[string]string)
+	for _, kv := range kvs {
+		result[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	return result
+}
+
+func TestOtlpHTTP(t *testing.T) {
+	for _, compression := range []string{"gzip", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			received := make(chan otlpReceived, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/metrics" {
+					t.Errorf("path is %s, want /v1/metrics", r.URL.Path)
+				}
+				var body io.Reader = r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					gz, err := gzip.NewReader(r.Body)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					body = gz
+				}
+				data, err := io.ReadAll(body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				req := &colmetricpb.ExportMetricsServiceRequest{}
+				if err := proto.Unmarshal(data, req); err != nil {
+					t.Error(err)
+					return
+				}
+				encoding := r.Header.Get("Content-Encoding")
+				if encoding == "" {
+					encoding = "none"
+				}
+				received <- otlpReceived{Request: req, Compression: encoding, Header: r.Header.Get("x-api-key")}
+				resp, _ := proto.Marshal(&colmetricpb.ExportMetricsServiceResponse{})
+				w.Header().Set("Content-Type", "application/x-protobuf")
+				_, _ = w.Write(resp)
+			}))
+			defer srv.Close()
+
+			sendOtlp(t, config.Otlp{Protocol: "http", Endpoint: strings.TrimPrefix(srv.URL, "http://"), Compression: compression})
+			select {
+			case got := <-received:
+				checkOtlpRequest(t, got, compression)
+			default:
+				t.Fatal("no request received")
+			}
+		})
+	}
+}
+
+type otlpGRPCReceiver struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	received chan otlpReceived
+	mu       sync.Mutex
+	encoding string
+}
+
+func (r *otlpGRPCReceiver) Export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := ""
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		header = values[0]
+	}
+	r.mu.Lock()
+	encoding := r.encoding
+	r.mu.Unlock()
+	r.received <- otlpReceived{Request: req, Compression: encoding, Header: header}
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+// TagRPC, HandleRPC, TagConn and HandleConn implement stats.Handler, to see the compression of the requests.
+func (r *otlpGRPCReceiver) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *otlpGRPCReceiver) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		r.mu.Lock()
+		r.encoding = h.Compression
+		if r.encoding == "" || r.encoding == "identity" {
+			r.encoding = "none"
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *otlpGRPCReceiver) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *otlpGRPCReceiver) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestOtlpGRPC(t *testing.T) {
+	for _, compression := range []string{"gzip", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			receiver := &otlpGRPCReceiver{received: make(chan otlpReceived, 1)}
+			srv := grpc.NewServer(grpc.StatsHandler(receiver))
+			colmetricpb.RegisterMetricsServiceServer(srv, receiver)
+			go func() { _ = srv.Serve(lis) }()
+			defer srv.Stop()
+
+			sendOtlp(t, config.Otlp{Protocol: "grpc", Endpoint: lis.Addr().String(), Compression: compression})
+			select {
+			case got := <-receiver.received:
+				checkOtlpRequest(t, got, compression)
+			default:
+				t.Fatal("no request received")
+			}
+		})
+	}
+}
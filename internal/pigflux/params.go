@@ -0,0 +1,191 @@
+package pigflux
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// Pass holds the metadata of a test pass.
+type Pass struct {
+	// Index is the zero based index of the pass
+	Index int
+	// Start is the time when the pass started. Relative times of bind parameters are relative to this.
+	Start time.Time
+	// Interval is the time between passes
+	Interval time.Duration
+	// Window is the time range of a backfill pass, nil for normal passes
+	Window *Window
+}
+
+// Window is a time range, given to the queries as the {start} and {end} bind parameters.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// queryRef matches the references of bind parameters in test SQL: {PARAMS[name]}, {start} and {end}
+var queryRef = regexp.MustCompile(config.ParamRef.String() + `|\{(start|end)}`)
+
+// previousRuns stores the start time of the last successful run, keyed by test and database name.
+var previousRuns sync.Map
+
+func runKey(testName string, dbname string) string {
+	return testName + "\x00" + dbname
+}
+
+// previousRun returns the start time of the last successful run of the test on the database.
+func previousRun(testName string, dbname string) (time.Time, bool) {
+	if t, ok := previousRuns.Load(runKey(testName, dbname)); ok {
+		return t.(time.Time), true
+	}
+	return time.Time{}, false
+}
+
+func setPreviousRun(testName string, dbname string, started time.Time) {
+	previousRuns.Store(runKey(testName, dbname), started)
+}
+
+// commitPreviousRuns stores the start times of the runs of a test (by database name), when all sinks accepted
+// the results. Otherwise the next run covers the time range of this run too.
+func commitPreviousRuns(testName string, runs map[string]time.Time, errs *SinkErrors) {
+	if len(runs) == 0 || errs.Err() != nil {
+		return
+	}
+	for dbname, started := range runs {
+		setPreviousRun(testName, dbname, started)
+	}
+}
+
+// placeholder returns the n-th (one based) bind parameter placeholder for the driver.
+func placeholder(driver string, n int) string {
+	switch driver {
+	case "mysql", "sqlite":
+		return "?"
+	case "sqlserver":
+		return fmt.Sprintf("@p%d", n)
+	}
+	return fmt.Sprintf("$%d", n)
+}
+
+// sqlTemplateData is passed to the sql templates of tests.
+type sqlTemplateData struct {
+	Vars        map[string]interface{}
+	Test        string
+	Database    string
+	Driver      string
+	Pass        Pass
+	PreviousRun time.Time
+}
+
+// buildQuery renders the sql template of the test (when enabled), and replaces the {PARAMS[name]}, {start} and
+// {end} references with the placeholders of the driver. It returns the query and the bind parameter values.
+func buildQuery(cf config.Config, dbname string, testName string, test config.Test, pass Pass,
+	query string) (string, []interface{}, error) {
+	db := cf.Databases[dbname]
+	prev, hasPrev := previousRun(testName, dbname)
+	if test.SQLTemplate {
+		vars := maps.Clone(cf.Vars)
+		if vars == nil {
+			vars = make(map[string]interface{})
+		}
+		maps.Copy(vars, db.Vars)
+		tmpl, ok := test.SQLTemplates[query]
+		if !ok {
+			var err error
+			tmpl, err = config.ParseSQLTemplate(testName, query)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+		buf := &bytes.Buffer{}
+		err := tmpl.Execute(buf, sqlTemplateData{Vars: vars, Test: testName, Database: dbname, Driver: db.Driver,
+			Pass: pass, PreviousRun: prev})
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot render sql template: %w", err)
+		}
+		query = buf.String()
+	}
+
+	params := make([]interface{}, 0)
+	var paramErr error
+	query = queryRef.ReplaceAllStringFunc(query, func(ref string) string {
+		m := queryRef.FindStringSubmatch(ref)
+		if m[2] != "" {
+			window := passWindow(pass, prev, hasPrev)
+			if m[2] == "start" {
+				params = append(params, window.Start)
+			} else {
+				params = append(params, window.End)
+			}
+			return placeholder(db.Driver, len(params))
+		}
+		name := m[1]
+		if name == config.WatermarkParam && test.Watermark.Enabled() {
+			value, err := watermarkValue(test, testName, dbname, pass.Start)
+			if err != nil {
+				paramErr = fmt.Errorf("watermark: %w", err)
+				return ref
+			}
+			params = append(params, value)
+			return placeholder(db.Driver, len(params))
+		}
+		param, ok := test.Params[name]
+		if !ok {
+			paramErr = fmt.Errorf("unknown param %s", name)
+			return ref
+		}
+		value, err := paramValue(param, dbname, testName, pass, prev, hasPrev)
+		if err != nil {
+			paramErr = fmt.Errorf("param %s: %w", name, err)
+			return ref
+		}
+		params = append(params, value)
+		return placeholder(db.Driver, len(params))
+	})
+	if paramErr != nil {
+		return "", nil, paramErr
+	}
+	return query, params, nil
+}
+
+// passWindow returns the time range of a pass. For normal passes, it starts at the previous successful run (one
+// interval before the pass on the first run), and ends at the start of the pass.
+func passWindow(pass Pass, prev time.Time, hasPrev bool) Window {
+	if pass.Window != nil {
+		return *pass.Window
+	}
+	if hasPrev {
+		return Window{Start: prev, End: pass.Start}
+	}
+	return Window{Start: pass.Start.Add(-pass.Interval), End: pass.Start}
+}
+
+// paramValue returns the value of a bind parameter for a run.
+func paramValue(param config.Param, dbname string, testName string, pass Pass, prev time.Time,
+	hasPrev bool) (interface{}, error) {
+	switch param.Type {
+	case config.ParamPassStart:
+		return pass.Start, nil
+	case config.ParamPreviousRun:
+		if pass.Window != nil {
+			return pass.Window.Start, nil
+		}
+		if hasPrev {
+			return prev, nil
+		}
+		if param.Value == "" {
+			return pass.Start, nil
+		}
+	case config.ParamDatabaseName:
+		return dbname, nil
+	case config.ParamTestName:
+		return testName, nil
+	}
+	return param.Resolve(pass.Start)
+}
@@ -0,0 +1,101 @@
+package pigflux
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func TestBuildQueryPlaceholders(t *testing.T) {
+	query := "SELECT * FROM t WHERE a > {PARAMS[since]} AND b = {PARAMS[name]} AND c < {end} AND d = {PARAMS[name]}"
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{"pgx", "SELECT * FROM t WHERE a > $1 AND b = $2 AND c < $3 AND d = $4"},
+		{"mysql", "SELECT * FROM t WHERE a > ? AND b = ? AND c < ? AND d = ?"},
+		{"sqlite", "SELECT * FROM t WHERE a > ? AND b = ? AND c < ? AND d = ?"},
+		{"sqlserver", "SELECT * FROM t WHERE a > @p1 AND b = @p2 AND c < @p3 AND d = @p4"},
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	since := config.Param{Type: config.ParamTime, Value: "now-1h30m"}
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			cf := config.Config{Databases: map[string]config.Database{"db": {Driver: tt.driver}}}
+			test := config.Test{Params: map[string]config.Param{"since": since, "name": {Type: config.ParamString, Value: "x"}}}
+			got, params, err := buildQuery(cf, "db", "placeholders_"+tt.driver, test, Pass{Start: start, Interval: time.Minute}, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got query %q, want %q", got, tt.want)
+			}
+			want := []interface{}{start.Add(-90 * time.Minute), "x", start, "x"}
+			if !slices.Equal(params, want) {
+				t.Errorf("got params %v, want %v", params, want)
+			}
+		})
+	}
+}
+
+func TestBuildQueryTemplate(t *testing.T) {
+	cf := config.Config{
+		Databases: map[string]config.Database{"db": {Driver: "pgx", Vars: map[string]interface{}{"table": "t2"}}},
+		Vars:      map[string]interface{}{"table": "t1", "limit": 10},
+	}
+	query := "SELECT * FROM {{.Vars.table}} WHERE d = {PARAMS[db]} LIMIT {{.Vars.limit}}"
+	test := config.Test{SQLTemplate: true, Params: map[string]config.Param{"db": {Type: config.ParamDatabaseName}}}
+	got, params, err := buildQuery(cf, "db", "template", test, Pass{Start: time.Now()}, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT * FROM t2 WHERE d = $1 LIMIT 10"; got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+	if !slices.Equal(params, []interface{}{"db"}) {
+		t.Errorf("got params %v", params)
+	}
+
+	// missing vars are errors
+	_, _, err = buildQuery(cf, "db", "template", test, Pass{Start: time.Now()}, "SELECT {{.Vars.missing}}")
+	if err == nil {
+		t.Error("missing var should be an error")
+	}
+}
+
+func TestPreviousRunAfterSinks(t *testing.T) {
+	db := sqliteDatabase(t, "CREATE TABLE t (size INTEGER)", "INSERT INTO t VALUES (1)")
+	for _, tt := range []struct {
+		status int
+		stored bool
+	}{{http.StatusInternalServerError, false}, {http.StatusOK, true}} {
+		srv, _ := webhookServer(t, tt.status)
+		testName := "previous_run_" + http.StatusText(tt.status)
+		cf := config.Config{
+			Databases: map[string]config.Database{"db": db},
+			Webhooks:  map[string]config.Webhook{"wh": {URL: srv.URL, Method: "POST", Timeout: 5 * time.Second}},
+			Tests: map[string]config.Test{testName: {
+				Databases: []string{"db"}, Webhooks: []string{"wh"}, Measurement: "m",
+				SQL: "SELECT size FROM t", Fields: []string{"size"},
+			}},
+		}
+		before := time.Now()
+		errs, err := runTest(cf, testName, Pass{Start: before, Interval: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (errs.Err() == nil) != tt.stored {
+			t.Fatalf("status %d: got sink errors %v", tt.status, errs.Err())
+		}
+		prev, ok := previousRun(testName, "db")
+		if ok != tt.stored {
+			t.Errorf("status %d: previous run stored: %v, want %v", tt.status, ok, tt.stored)
+		}
+		if ok && prev.Before(before) {
+			t.Errorf("previous run %v is before the run", prev)
+		}
+	}
+}
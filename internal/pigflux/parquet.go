@@ -0,0 +1,337 @@
+package pigflux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+type parquetRow struct {
+	Time   time.Time
+	Result TestResult
+}
+
+// parquetBuffer holds the rows of a single measurement until they are written into a new parquet file.
+type parquetBuffer struct {
+	Name        string
+	Cfg         config.Parquet
+	Measurement string
+	Date        string
+	Started     time.Time
+	Rows        []parquetRow
+}
+
+// parquetBuffers are keyed by parquet sink name, target directory, measurement and date. They live between
+// test runs, and they are written out when they grow too big or too old, when the date changes, and on
+// Shutdown. Buffers that could not be written are kept, and written out later.
+var parquetBuffers = make(map[string]*parquetBuffer)
+var parquetLock sync.Mutex
+
+func SendTestResultsParquet(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	test := cf.Tests[name]
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	// partitions are dated by the time of the results, so backfilled results go to the partitions of their dates
+	dates := make(map[string]bool)
+
+	parquetLock.Lock()
+	defer parquetLock.Unlock()
+	for _, pname := range test.Parquets {
+		pcfg := cf.Parquets[pname]
+		for _, result := range results {
+			date := result.timestamp().UTC().Format("2006-01-02")
+			dates[date] = true
+			key := pname + "\x00" + pcfg.Directory + "\x00" + result.Measurement + "\x00" + date
+			buf, ok := parquetBuffers[key]
+			if !ok {
+				buf = &parquetBuffer{Name: pname, Cfg: pcfg, Measurement: result.Measurement, Date: date, Started: now}
+				parquetBuffers[key] = buf
+			}
+			buf.Rows = append(buf.Rows, parquetRow{Time: result.timestamp(), Result: result})
+		}
+	}
+	for key, buf := range parquetBuffers {
+		// buffers of past dates are complete, unless results of their date are still coming (e.g. in a backfill)
+		stale := buf.Date < today && !dates[buf.Date]
+		if stale || len(buf.Rows) >= buf.Cfg.MaxRows || now.Sub(buf.Started) >= buf.Cfg.MaxAge {
+			err := flushParquetBuffer(key)
+			if err != nil && slices.Contains(test.Parquets, buf.Name) {
+				errs.Error("could not write parquet file", "type", "parquet", "name", buf.Name, "rows", len(buf.Rows), "error", err)
+			}
+		}
+	}
+}
+
+// FlushParquets writes out all buffered parquet rows.
+func FlushParquets() {
+	parquetLock.Lock()
+	defer parquetLock.Unlock()
+	for key, buf := range parquetBuffers {
+		err := flushParquetBuffer(key)
+		if err != nil {
+			slog.Error("could not write parquet file, buffered rows are lost", "type", "parquet", "name", buf.Name, "rows", len(buf.Rows), "error", err)
+		}
+	}
+}
+
+// flushTestParquets writes out the buffered rows of the parquet sinks of a test.
+func flushTestParquets(cf config.Config, testName string) error {
+	parquetLock.Lock()
+	defer parquetLock.Unlock()
+	var errs []error
+	for key, buf := range parquetBuffers {
+		if !slices.Contains(cf.Tests[testName].Parquets, buf.Name) {
+			continue
+		}
+		err := flushParquetBuffer(key)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flushParquetBuffer writes the buffer into a new file, and removes it. When the file cannot be written, the
+// buffer is kept so that the rows are written out by a later flush. The caller must hold parquetLock.
+func flushParquetBuffer(key string) error {
+	buf := parquetBuffers[key]
+	if len(buf.Rows) == 0 {
+		delete(parquetBuffers, key)
+		return nil
+	}
+	dir := filepath.Join(buf.Cfg.Directory, buf.Measurement, "date="+buf.Date)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.parquet", buf.Measurement, time.Now().UTC().Format("20060102T150405.000000")))
+	err := writeParquetFile(buf, path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	delete(parquetBuffers, key)
+	slog.Debug(fmt.Sprintf("Written %d row(s) into %s", len(buf.Rows), path))
+	return nil
+}
+
+const (
+	parquetKindNull = iota
+	parquetKindInt
+	parquetKindFloat
+	parquetKindBool
+	parquetKindString
+	parquetKindTime
+)
+
+func parquetKind(value interface{}) int {
+	switch v := value.(type) {
+	case nil:
+		return parquetKindNull
+	case uint:
+		// unsigned values that do not fit into an int64 column are stored as floats
+		if uint64(v) > math.MaxInt64 {
+			return parquetKindFloat
+		}
+		return parquetKindInt
+	case uint64:
+		if v > math.MaxInt64 {
+			return parquetKindFloat
+		}
+		return parquetKindInt
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return parquetKindInt
+	case float32, float64:
+		return parquetKindFloat
+	case bool:
+		return parquetKindBool
+	case time.Time:
+		return parquetKindTime
+	}
+	return parquetKindString
+}
+
+// mergeParquetKinds returns a column type that can hold values of both kinds.
+func mergeParquetKinds(a, b int) int {
+	if a == parquetKindNull || a == b {
+		return b
+	}
+	if b == parquetKindNull {
+		return a
+	}
+	if (a == parquetKindInt || a == parquetKindFloat) && (b == parquetKindInt || b == parquetKindFloat) {
+		return parquetKindFloat
+	}
+	return parquetKindString
+}
+
+func parquetArrowType(kind int) arrow.DataType {
+	switch kind {
+	case parquetKindInt:
+		return arrow.PrimitiveTypes.Int64
+	case parquetKindFloat:
+		return arrow.PrimitiveTypes.Float64
+	case parquetKindBool:
+		return arrow.FixedWidthTypes.Boolean
+	case parquetKindTime:
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	}
+	return arrow.BinaryTypes.String
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	}
+	return 0
+}
+
+func appendParquetValue(b array.Builder, kind int, value interface{}) {
+	if value == nil {
+		b.AppendNull()
+		return
+	}
+	switch kind {
+	case parquetKindInt:
+		b.(*array.Int64Builder).Append(toInt64(value))
+	case parquetKindFloat:
+		f, _ := toFloat64(value)
+		b.(*array.Float64Builder).Append(f)
+	case parquetKindBool:
+		b.(*array.BooleanBuilder).Append(value.(bool))
+	case parquetKindTime:
+		b.(*array.TimestampBuilder).Append(arrow.Timestamp(value.(time.Time).UnixMicro()))
+	default:
+		if v, ok := value.([]byte); ok {
+			value = string(v)
+		}
+		b.(*array.StringBuilder).Append(fmt.Sprintf("%v", value))
+	}
+}
+
+// writeParquetFile writes the buffered rows into a parquet file. The schema is built from all rows:
+// a time column, one dictionary encoded string column for each tag, and one column for each field,
+// with the type inferred from the field values.
+func writeParquetFile(buf *parquetBuffer, path string) error {
+	tagNames := make(map[string]bool)
+	fieldKinds := make(map[string]int)
+	for _, row := range buf.Rows {
+		for name := range row.Result.Tags {
+			tagNames[name] = true
+		}
+		for name, value := range row.Result.Fields {
+			fieldKinds[name] = mergeParquetKinds(fieldKinds[name], parquetKind(value))
+		}
+	}
+	tags := slices.Sorted(maps.Keys(tagNames))
+	fields := slices.Sorted(maps.Keys(fieldKinds))
+
+	columns := []arrow.Field{{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}}}
+	for _, tag := range tags {
+		column := tag
+		if _, ok := fieldKinds[tag]; ok || tag == "time" {
+			column = "tag_" + tag
+		}
+		columns = append(columns, arrow.Field{
+			Name:     column,
+			Type:     &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.BinaryTypes.String},
+			Nullable: true,
+		})
+	}
+	for _, field := range fields {
+		column := field
+		if field == "time" {
+			column = "field_" + field
+		}
+		columns = append(columns, arrow.Field{Name: column, Type: parquetArrowType(fieldKinds[field]), Nullable: true})
+	}
+	schema := arrow.NewSchema(columns, nil)
+
+	rb := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer rb.Release()
+	for _, row := range buf.Rows {
+		rb.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(row.Time.UnixMicro()))
+		for i, tag := range tags {
+			b := rb.Field(1 + i).(*array.BinaryDictionaryBuilder)
+			value, ok := row.Result.Tags[tag]
+			if !ok {
+				b.AppendNull()
+				continue
+			}
+			err := b.AppendString(value)
+			if err != nil {
+				return err
+			}
+		}
+		for i, field := range fields {
+			appendParquetValue(rb.Field(1+len(tags)+i), fieldKinds[field], row.Result.Fields[field])
+		}
+	}
+	rec := rb.NewRecord()
+	defer rec.Release()
+
+	codec := compress.Codecs.Snappy
+	switch buf.Cfg.Compression {
+	case "gzip":
+		codec = compress.Codecs.Gzip
+	case "zstd":
+		codec = compress.Codecs.Zstd
+	case "none":
+		codec = compress.Codecs.Uncompressed
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	// Write into a temporary file first, so readers never see partially written files.
+	tmp := path + ".tmp"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w, err := pqarrow.NewFileWriter(schema, fd,
+		parquet.NewWriterProperties(parquet.WithCompression(codec)),
+		pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return errors.Join(err, fd.Close(), os.Remove(tmp))
+	}
+	err = w.Write(rec)
+	// Closing the file writer also closes the underlying file.
+	err = errors.Join(err, w.Close())
+	if err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,144 @@
+package pigflux
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func sendParquet(cf config.Config, results []TestResult) error {
+	errs := &SinkErrors{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	SendTestResultsParquet(context.Background(), cf, "test", results, errs, wg)
+	wg.Wait()
+	return errs.Err()
+}
+
+func readParquetTable(t *testing.T, path string) arrow.Table {
+	t.Helper()
+	rdr, err := file.OpenParquetFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	fr, err := pqarrow.NewFileReader(rdr, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := fr.ReadTable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(table.Release)
+	return table
+}
+
+func parquetFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*", "date=*", "*.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestParquetSinksAreSeparate(t *testing.T) {
+	dir := t.TempDir()
+	// two sinks with the same directory, but different limits
+	cf := config.Config{
+		Parquets: map[string]config.Parquet{
+			"p1": {Directory: dir, MaxRows: 1, MaxAge: time.Hour},
+			"p2": {Directory: dir, MaxRows: 100, MaxAge: time.Hour},
+		},
+		Tests: map[string]config.Test{"test": {Parquets: []string{"p1", "p2"}}},
+	}
+	t.Cleanup(FlushParquets)
+	result := TestResult{Measurement: "m", Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{}}
+	if err := sendParquet(cf, []TestResult{result}); err != nil {
+		t.Fatal(err)
+	}
+	if files := parquetFiles(t, dir); len(files) != 1 {
+		t.Fatalf("got files %v, want only the file of p1", files)
+	}
+	parquetLock.Lock()
+	buffered := len(parquetBuffers)
+	parquetLock.Unlock()
+	if buffered != 1 {
+		t.Errorf("got %d buffers, want the buffer of p2", buffered)
+	}
+}
+
+func TestParquetFailedWriteIsKept(t *testing.T) {
+	dir := t.TempDir()
+	// the directory cannot be created, because a file is in the way
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cf := config.Config{
+		Parquets: map[string]config.Parquet{"p": {Directory: blocked, MaxRows: 1, MaxAge: time.Hour}},
+		Tests:    map[string]config.Test{"test": {Parquets: []string{"p"}}},
+	}
+	t.Cleanup(FlushParquets)
+	result := TestResult{Measurement: "m", Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{}}
+	if err := sendParquet(cf, []TestResult{result}); err == nil {
+		t.Fatal("expected a write error")
+	}
+
+	// after the problem is fixed, the rows of the failed write are written out with the new ones
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendParquet(cf, []TestResult{result}); err != nil {
+		t.Fatal(err)
+	}
+	files := parquetFiles(t, blocked)
+	if len(files) != 1 {
+		t.Fatalf("got files %v, want 1", files)
+	}
+	if rows := readParquetTable(t, files[0]).NumRows(); rows != 2 {
+		t.Errorf("got %d rows, want 2", rows)
+	}
+}
+
+func TestParquetUnsignedOverflow(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.parquet")
+	buf := &parquetBuffer{Cfg: config.Parquet{}, Measurement: "m", Rows: []parquetRow{
+		{Time: time.Now(), Result: TestResult{Fields: map[string]interface{}{"small": uint64(7), "big": uint64(math.MaxUint64)}}},
+	}}
+	if err := writeParquetFile(buf, path); err != nil {
+		t.Fatal(err)
+	}
+	table := readParquetTable(t, path)
+	for i := 0; i < int(table.NumCols()); i++ {
+		col := table.Column(i)
+		chunk := col.Data().Chunk(0)
+		switch col.Name() {
+		case "small":
+			if v := chunk.(*array.Int64).Value(0); v != 7 {
+				t.Errorf("small is %d, want 7", v)
+			}
+		case "big":
+			arr, ok := chunk.(*array.Float64)
+			if !ok {
+				t.Fatalf("big is stored as %s, want double", col.DataType())
+			}
+			if v := arr.Value(0); v != float64(uint64(math.MaxUint64)) {
+				t.Errorf("big is %v, want %v", v, float64(uint64(math.MaxUint64)))
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package pigflux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+type promSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+type promResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// fetchPrometheus runs an instant PromQL query (given in the sql of the test). Each returned series becomes
+// a result row: the labels become tags (except __name__), and the value is stored in the only field of the test.
+// Samples with NaN or infinite values are dropped, because most sinks cannot store them.
+func fetchPrometheus(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+	pcfg := cf.Prometheus[dbname]
+	cl, err := newHTTPClient(pcfg.TLS, pcfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create http client %s: %w", dbname, err)
+	}
+	defer cl.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pcfg.Timeout)
+	defer cancel()
+	form := url.Values{"query": {strings.TrimSpace(test.SQL)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(pcfg.URL, "/")+"/api/v1/query", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, value := range pcfg.Headers {
+		req.Header.Set(key, value)
+	}
+	setHTTPAuth(req, pcfg.Username, pcfg.Password, pcfg.BearerToken)
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr promResponse
+	err = json.Unmarshal(body, &pr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse prometheus response (status %s): %w", resp.Status, err)
+	}
+	if pr.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s: %s", pr.ErrorType, pr.Error)
+	}
+
+	field := test.Fields[0]
+	samples := make([]promSample, 0)
+	switch pr.Data.ResultType {
+	case "vector":
+		err = json.Unmarshal(pr.Data.Result, &samples)
+	case "scalar":
+		var value []interface{}
+		err = json.Unmarshal(pr.Data.Result, &value)
+		samples = append(samples, promSample{Value: value})
+	default:
+		return nil, fmt.Errorf("unsupported prometheus result type %s, only vector and scalar are supported", pr.Data.ResultType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse prometheus result: %w", err)
+	}
+
+	result := make([]FetchResult, 0, len(samples))
+	for _, sample := range samples {
+		if len(sample.Value) != 2 {
+			return nil, fmt.Errorf("invalid prometheus sample value: %v", sample.Value)
+		}
+		s, _ := sample.Value[1].(string)
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prometheus sample value %v: %w", sample.Value[1], err)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			slog.Debug("dropping prometheus sample with a non-finite value", "dbname", dbname, "metric", sample.Metric, "value", s)
+			continue
+		}
+		columns := []string{field}
+		values := []interface{}{value}
+		for _, label := range slices.Sorted(maps.Keys(sample.Metric)) {
+			if label == "__name__" || label == field {
+				continue
+			}
+			columns = append(columns, label)
+			values = append(values, sample.Metric[label])
+		}
+		err = checkColumns(columns)
+		if err != nil {
+			return nil, err
+		}
+		fr, err := newFetchResult(test, columns, values)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fr)
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package pigflux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func prometheusServer(t *testing.T, response string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.FormValue("query") != "up" {
+			t.Errorf("unexpected request %s query=%q", r.URL.Path, r.FormValue("query"))
+		}
+		if got := r.Header.Get("X-Scope-OrgID"); got != "tenant1" {
+			t.Errorf("X-Scope-OrgID is %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fetchPrometheusStub(t *testing.T, response string) ([]FetchResult, error) {
+	t.Helper()
+	srv := prometheusServer(t, response)
+	cf := config.Config{Prometheus: map[string]config.Prometheus{"prom": {
+		URL:     srv.URL + "/",
+		Headers: map[string]string{"X-Scope-OrgID": "tenant1"},
+		Timeout: 5 * time.Second,
+	}}}
+	return fetchPrometheus(cf, "prom", config.Test{SQL: " up ", Fields: []string{"value"}})
+}
+
+func TestPrometheusVector(t *testing.T) {
+	results, err := fetchPrometheusStub(t, `{"status":"success","data":{"resultType":"vector","result":[
+{"metric":{"__name__":"up","job":"node","instance":"h1"},"value":[1700000000.1,"1"]},
+{"metric":{"__name__":"up","job":"node","instance":"h2"},"value":[1700000000.1,"NaN"]},
+{"metric":{"__name__":"up","job":"node","instance":"h3"},"value":[1700000000.1,"+Inf"]},
+{"metric":{"__name__":"up","job":"node","instance":"h4"},"value":[1700000000.1,"-Inf"]},
+{"metric":{"__name__":"up","job":"node","instance":"h5"},"value":[1700000000.1,"0.5"]}]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want the 2 finite samples", len(results))
+	}
+	if r := results[0]; r.Fields["value"] != 1.0 || r.Tags["instance"] != "h1" || r.Tags["job"] != "node" || len(r.Tags) != 2 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r := results[1]; r.Fields["value"] != 0.5 || r.Tags["instance"] != "h5" {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestPrometheusScalar(t *testing.T) {
+	results, err := fetchPrometheusStub(t, `{"status":"success","data":{"resultType":"scalar","result":[1700000000,"42"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Fields["value"] != 42.0 || len(results[0].Tags) != 0 {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestPrometheusErrors(t *testing.T) {
+	_, err := fetchPrometheusStub(t, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+	if err == nil {
+		t.Error("expected an error for a failed query")
+	}
+	_, err = fetchPrometheusStub(t, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+	if err == nil {
+		t.Error("expected an error for a range result")
+	}
+}
@@ -0,0 +1,197 @@
+package pigflux
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// fetchRedis runs the redis command given in the sql of the test.
+//
+// For INFO, the reply is parsed into key/value pairs, and the keys listed in the fields of the test become fields,
+// other keys are ignored. Values like "keys=1,expires=0" (keyspace, commandstats) are expanded into db0_keys,
+// db0_expires etc.
+//
+// For other commands, a single value reply is stored in the first field of the test. Map replies and flat
+// key/value array replies (e.g. XINFO STREAM) are converted into columns, the usual way. Nested values are ignored.
+//
+// The timeout of the redis source applies to connecting and to each read and write, the query_timeout of the test
+// limits the whole command.
+func fetchRedis(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+	rcfg := cf.Redises[dbname]
+	opts := &redis.Options{
+		Addr:         rcfg.Address,
+		Username:     rcfg.Username,
+		Password:     rcfg.Password,
+		DB:           rcfg.DB,
+		DialTimeout:  rcfg.Timeout,
+		ReadTimeout:  rcfg.Timeout,
+		WriteTimeout: rcfg.Timeout,
+		// the deadline of the query context also applies to the reads and writes
+		ContextTimeoutEnabled: true,
+	}
+	if rcfg.UseTLS {
+		tlsCfg, err := newTLSConfig(rcfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		opts.TLSConfig = tlsCfg
+	}
+	cl := redis.NewClient(opts)
+	defer func() {
+		err := cl.Close()
+		if err != nil {
+			slog.Warn("could not close connection", "dbname", dbname, "error", err.Error())
+		}
+	}()
+
+	args, err := splitCommand(test.SQL)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no redis command given")
+	}
+	ctx, cancel := queryContext(test)
+	defer cancel()
+	cmdArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmdArgs[i] = arg
+	}
+	reply, err := cl.Do(ctx, cmdArgs...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]interface{})
+	if strings.EqualFold(args[0], "INFO") {
+		text, ok := reply.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected INFO reply type %T", reply)
+		}
+		info := parseRedisInfo(text)
+		for _, field := range test.Fields {
+			if value, ok := info[field]; ok {
+				row[field] = value
+			}
+		}
+	} else {
+		switch v := reply.(type) {
+		case map[interface{}]interface{}:
+			for key, value := range v {
+				addRedisColumn(row, fmt.Sprintf("%v", key), value)
+			}
+		case []interface{}:
+			if len(v)%2 != 0 {
+				return nil, fmt.Errorf("unsupported array reply with %d items, only key/value pairs are supported", len(v))
+			}
+			for i := 0; i < len(v); i += 2 {
+				addRedisColumn(row, fmt.Sprintf("%v", v[i]), v[i+1])
+			}
+		default:
+			if len(test.Fields) > 0 {
+				row[test.Fields[0]] = redisValue(reply)
+			}
+		}
+	}
+	fr, err := rowFetchResult(test, row)
+	if err != nil {
+		return nil, err
+	}
+	return []FetchResult{fr}, nil
+}
+
+// addRedisColumn adds a scalar value to the row, with a column name that has dashes replaced.
+func addRedisColumn(row map[string]interface{}, key string, value interface{}) {
+	switch value.(type) {
+	case []interface{}, map[interface{}]interface{}, map[string]interface{}:
+		return
+	}
+	row[strings.ReplaceAll(key, "-", "_")] = redisValue(value)
+}
+
+// redisValue converts numeric strings into numbers.
+func redisValue(value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// parseRedisInfo parses the reply of the INFO command.
+func parseRedisInfo(text string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ReplaceAll(key, "-", "_")
+		result[key] = redisValue(value)
+		if strings.Contains(value, "=") {
+			for _, part := range strings.Split(value, ",") {
+				subKey, subValue, ok := strings.Cut(part, "=")
+				if ok {
+					result[key+"_"+strings.ReplaceAll(subKey, "-", "_")] = redisValue(subValue)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// splitCommand splits a command line into arguments. Arguments can be quoted with single or double quotes.
+func splitCommand(command string) ([]string, error) {
+	args := make([]string, 0)
+	current := strings.Builder{}
+	inArg := false
+	var quote rune
+	for _, r := range strings.TrimSpace(command) {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
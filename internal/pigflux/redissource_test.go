@@ -0,0 +1,93 @@
+package pigflux
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func redisConfig(address string) config.Config {
+	return config.Config{Redises: map[string]config.Redis{"rd": {Address: address, Timeout: 5 * time.Second}}}
+}
+
+func TestFetchRedis(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.Set("counter", "42")
+	srv.HSet("h", "name", "pigflux", "size-bytes", "1.5")
+	cf := redisConfig(srv.Addr())
+
+	results, err := fetchRedis(cf, "rd", config.Test{SQL: "GET counter", Fields: []string{"value"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Fields["value"] != int64(42) {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	results, err = fetchRedis(cf, "rd", config.Test{SQL: "HGETALL 'h'", Fields: []string{"size_bytes"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Fields["size_bytes"] != 1.5 || results[0].Tags["name"] != "pigflux" {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestParseRedisInfo(t *testing.T) {
+	info := parseRedisInfo("# Server\r\nredis_version:7.2.4\r\nuptime_in_seconds:100\r\n\r\n# Keyspace\r\ndb0:keys=3,expires=1,avg-ttl=0\r\n")
+	for key, want := range map[string]interface{}{
+		"redis_version":     "7.2.4",
+		"uptime_in_seconds": int64(100),
+		"db0_keys":          int64(3),
+		"db0_expires":       int64(1),
+		"db0_avg_ttl":       int64(0),
+	} {
+		if info[key] != want {
+			t.Errorf("%s is %#v, want %#v", key, info[key], want)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	args, err := splitCommand(` XINFO  STREAM "my stream" 'a b' `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"XINFO", "STREAM", "my stream", "a b"}; !slices.Equal(args, want) {
+		t.Errorf("got %q, want %q", args, want)
+	}
+	if _, err := splitCommand(`GET "key`); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestFetchRedisQueryTimeout(t *testing.T) {
+	// a server that accepts connections, but never answers
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	started := time.Now()
+	_, err = fetchRedis(redisConfig(lis.Addr().String()), "rd", config.Test{SQL: "GET counter", Fields: []string{"value"}, QueryTimeout: 200 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("the command took %v, the query timeout was not applied", elapsed)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/nagylzs/pigflux/internal/state"
 	"github.com/nagylzs/set"
 )
 
@@ -16,57 +18,169 @@ type TestResult struct {
 	Measurement string
 	Fields      map[string]interface{}
 	Tags        map[string]string
+	// Time is the timestamp of the result, zero means the time of sending (e.g. backfills use the window end)
+	Time time.Time
 }
 
-func RunTest(cf config.Config, testName string) error {
+// timestamp returns the time of the result, or the current time when it is not given.
+func (r TestResult) timestamp() time.Time {
+	if r.Time.IsZero() {
+		return time.Now()
+	}
+	return r.Time
+}
+
+// resultsTime returns the timestamp of a batch of results. Results of a test run share the same time.
+func resultsTime(results []TestResult) time.Time {
+	if len(results) > 0 {
+		return results[0].timestamp()
+	}
+	return time.Now()
+}
+
+// RunTest runs a test on all of its databases, and sends the results to all sinks. Errors of the sinks are
+// logged, they are not returned.
+func RunTest(cf config.Config, testName string, pass Pass) error {
+	_, err := runTest(cf, testName, pass)
+	return err
+}
+
+// RunBackfillWindow runs a test for a single backfill window, the results are stamped with the end of the window.
+// Unlike RunTest, it also returns an error when a sink did not accept the results.
+func RunBackfillWindow(cf config.Config, testName string, index int, window Window) error {
+	pass := Pass{Index: index, Start: window.End, Interval: window.End.Sub(window.Start), Window: &window}
+	errs, err := runTest(cf, testName, pass)
+	if err != nil {
+		return err
+	}
+	if err := errs.Err(); err != nil {
+		return fmt.Errorf("not all sinks accepted the results: %w", err)
+	}
+	// the window is only done when its rows are on disk, buffered rows would be lost when the process stops
+	if err := flushTestParquets(cf, testName); err != nil {
+		return fmt.Errorf("could not write parquet file: %w", err)
+	}
+	return nil
+}
+
+// runTest runs a test, and returns the errors of the sinks.
+func runTest(cf config.Config, testName string, pass Pass) (*SinkErrors, error) {
 	test := cf.Tests[testName]
+	var resultTime time.Time
+	if pass.Window != nil {
+		resultTime = pass.Window.End
+	}
 	testResults := make([]TestResult, 0)
+	watermarks := make(map[string]state.Value)
+	runs := make(map[string]time.Time)
 	for _, dbname := range test.Databases {
 		slog.Info(fmt.Sprintf("Running test %s on database %s", testName, dbname))
 		//ctx, cancel := context.WithTimeout(context.Background(), test.Timeout)
 		started := time.Now()
-		fetchResults, err := fetchTest(cf, dbname, test)
+		fetchResults, err := fetchSource(cf, dbname, testName, test, pass)
 		//cancel()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		elapsed := time.Since(started)
+		if pass.Window == nil {
+			runs[dbname] = started
+		}
+		if test.Watermark.Enabled() && pass.Window == nil {
+			wm, found, err := maxWatermark(test, fetchResults)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				watermarks[dbname] = wm
+			}
+		}
 		slog.Debug(fmt.Sprintf("Test %s on database %s returned %d data point(s)", testName, dbname, len(fetchResults)))
 		for idx, fr := range fetchResults {
 			fr.Fields["q_elapsed"] = elapsed.Seconds()
 			fr.Tags["database_name"] = dbname
+			if db, ok := cf.Databases[dbname]; ok && db.DatabaseName != "" {
+				fr.Tags["database_name"] = db.DatabaseName
+				fr.Tags["server_name"] = db.ServerName
+			}
 			for name, tag := range test.Tags {
 				fr.Tags[name] = tag
 			}
-			slog.Debug(fmt.Sprintf("Test %s point #%d on database %s: fields=%v tags=%v",
-				testName, idx, dbname, fr.Fields, fr.Tags))
+			slog.Debug(fmt.Sprintf("Test %s point #%d on database %s", testName, idx, dbname),
+				"fields", fr.Fields, "tags", fr.Tags)
 			testResults = append(testResults, TestResult{
 				Measurement: test.Measurement,
 				Fields:      fr.Fields,
 				Tags:        fr.Tags,
+				Time:        resultTime,
 			})
 		}
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
+	errs := &SinkErrors{}
 	wg := &sync.WaitGroup{}
-	wg.Add(4)
-	go SendTestResultsV1(ctx, cf, testName, testResults, wg)
-	go SendTestResultsV2(ctx, cf, testName, testResults, wg)
-	go SendTestResultsV3(ctx, cf, testName, testResults, wg)
-	go SendTestResultsDb(ctx, cf, testName, testResults, wg)
+	wg.Add(11)
+	go SendTestResultsV1(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsV2(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsV3(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsStatsd(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsOtlp(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsFile(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsParquet(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsWebhook(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsKafka(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsMqtt(ctx, cf, testName, testResults, errs, wg)
+	go SendTestResultsDb(ctx, cf, testName, testResults, errs, wg)
 	wg.Wait()
+	commitWatermarks(testName, watermarks, errs)
+	commitPreviousRuns(testName, runs, errs)
 
-	return nil
+	return errs, nil
+}
+
+// Shutdown writes out buffered test results, and closes long-lived connections. It should be called
+// before the program exits.
+func Shutdown() {
+	FlushParquets()
+	CloseMqtts()
 }
 
 type FetchResult struct {
 	Fields map[string]interface{}
 	Tags   map[string]string
+	// Watermark is the value of the watermark column of the test, if any
+	Watermark interface{}
+}
+
+// fetchSource runs the test on the named source, which can be an SQL database, an influx instance, a command,
+// a HTTP endpoint, a prometheus server or a redis server.
+func fetchSource(cf config.Config, dbname string, testName string, test config.Test, pass Pass) ([]FetchResult, error) {
+	st, err := cf.SourceType(dbname)
+	if err != nil {
+		return nil, err
+	}
+	switch st {
+	case config.SourceInflux:
+		return fetchInflux(cf, dbname, test)
+	case config.SourceInflux2:
+		return fetchInflux2(cf, dbname, test)
+	case config.SourceInflux3:
+		return fetchInflux3(cf, dbname, test)
+	case config.SourceExec:
+		return fetchExec(cf, dbname, test)
+	case config.SourceHTTP:
+		return fetchHTTP(cf, dbname, test)
+	case config.SourcePrometheus:
+		return fetchPrometheus(cf, dbname, test)
+	case config.SourceRedis:
+		return fetchRedis(cf, dbname, test)
+	}
+	return fetchTest(cf, dbname, testName, test, pass)
 }
 
-func fetchTest(cf config.Config, dbname string, test config.Test) ([]FetchResult, error) {
+func fetchTest(cf config.Config, dbname string, testName string, test config.Test, pass Pass) ([]FetchResult, error) {
 	// TODO use QueryTimeout here!
 	db := cf.Databases[dbname]
 	conn, err := sql.Open(db.Driver, db.DSN)
@@ -80,8 +194,18 @@ func fetchTest(cf config.Config, dbname string, test config.Test) ([]FetchResult
 		}
 	}()
 
-	rows, err := conn.Query(test.SQL)
+	query, err := testSQL(conn, db, dbname, test)
+	if err != nil {
+		return nil, err
+	}
+	query, params, err := buildQuery(cf, dbname, testName, test, pass, query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(query, params...)
 	if err != nil {
+		// the server may have been replaced with a different version
+		forgetServerVersion(db)
 		return nil, err
 	}
 	defer func() {
@@ -95,10 +219,12 @@ func fetchTest(cf config.Config, dbname string, test config.Test) ([]FetchResult
 	if err != nil {
 		return nil, err
 	}
-	for _, column := range columns {
-		if !config.IsIdentifierLike(column) {
-			return nil, fmt.Errorf("invalid column: %s, only [a-zA-Z][a-zA-Z0-9]* is supported", column)
-		}
+	err = checkColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	if test.Watermark.Enabled() && !slices.Contains(columns, test.Watermark.Column) {
+		return nil, fmt.Errorf("watermark column %s is missing from the result", test.Watermark.Column)
 	}
 
 	result := make([]FetchResult, 0)
@@ -114,31 +240,56 @@ func fetchTest(cf config.Config, dbname string, test config.Test) ([]FetchResult
 			return nil, err
 		}
 
-		fields := make(map[string]interface{})
-		tags := make(map[string]string)
-		fs := set.FromArray(test.Fields)
-		got := set.NewSet[string]()
-		for i, col := range columns {
-			val := values[i]
-
-			if fs.Contains(col) {
-				// Convert []byte to string for readability
-				if b, ok := val.([]byte); ok {
-					fields[col] = string(b)
-				} else {
-					fields[col] = val
-				}
-				got.Add(col)
-			} else {
-				tags[col] = fmt.Sprintf("%v", val)
-			}
-		}
-		missing := fs.Difference(got)
-		if !missing.Empty() {
-			return nil, fmt.Errorf("missing fields: %v (specified in 'fields' but missing from result", missing)
+		fr, err := newFetchResult(test, columns, values)
+		if err != nil {
+			return nil, err
 		}
-		result = append(result, FetchResult{Fields: fields, Tags: tags})
+		result = append(result, fr)
 	}
 	return result, nil
 
 }
+
+func checkColumns(columns []string) error {
+	for _, column := range columns {
+		if !config.IsIdentifierLike(column) {
+			return fmt.Errorf("invalid column: %s, only [a-zA-Z][a-zA-Z0-9]* is supported", column)
+		}
+	}
+	return nil
+}
+
+// newFetchResult creates a FetchResult from a result row. Columns listed in the fields of the test become
+// fields, all other columns become tags, except NULL values. All fields of the test must be present in the row. The watermark column
+// of the test is not a tag, unless it is listed in the fields it is only used for the watermark.
+func newFetchResult(test config.Test, columns []string, values []interface{}) (FetchResult, error) {
+	fields := make(map[string]interface{})
+	tags := make(map[string]string)
+	fs := set.FromArray(test.Fields)
+	got := set.NewSet[string]()
+	var watermark interface{}
+	for i, col := range columns {
+		val := values[i]
+		if test.Watermark.Enabled() && col == test.Watermark.Column {
+			watermark = val
+		}
+
+		if fs.Contains(col) {
+			// Convert []byte to string for readability
+			if b, ok := val.([]byte); ok {
+				fields[col] = string(b)
+			} else {
+				fields[col] = val
+			}
+			got.Add(col)
+		} else if val != nil && (!test.Watermark.Enabled() || col != test.Watermark.Column) {
+			// NULL values (and missing values of sparse influx rows) are not tags
+			tags[col] = fmt.Sprintf("%v", val)
+		}
+	}
+	missing := fs.Difference(got)
+	if !missing.Empty() {
+		return FetchResult{}, fmt.Errorf("missing fields: %v (specified in 'fields' but missing from result", missing)
+	}
+	return FetchResult{Fields: fields, Tags: tags, Watermark: watermark}, nil
+}
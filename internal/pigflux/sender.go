@@ -3,14 +3,12 @@ package pigflux
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log/slog"
 	"maps"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/InfluxCommunity/influxdb3-go/v2/influxdb3"
 	influxdb2 "github.com/influxdata/influxdb-client-go"
@@ -19,26 +17,27 @@ import (
 	"github.com/nagylzs/pigflux/internal/config"
 )
 
-func SendTestResultsV1(ctx context.Context, cf config.Config, name string, results []TestResult, wg *sync.WaitGroup) {
+func SendTestResultsV1(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	test := cf.Tests[name]
 	conns := ConnectInfluxes(cf, test.Influxes)
+	errs.connected("influx", test.Influxes, len(conns))
 	defer CloseInfluxes(conns)
 	wg2 := &sync.WaitGroup{}
 	wg2.Add(len(conns))
 	for _, conn := range conns {
-		go SendTestResultsV1Conn(ctx, cf, name, results, conn, wg2)
+		go SendTestResultsV1Conn(ctx, cf, name, results, conn, errs, wg2)
 	}
 	wg2.Wait()
 }
 
-func SendTestResultsV1Conn(ctx context.Context, cf config.Config, name string, results []TestResult, conn IConnV1, wg *sync.WaitGroup) {
+func SendTestResultsV1Conn(ctx context.Context, cf config.Config, name string, results []TestResult, conn IConnV1, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	bp, err := client.NewBatchPoints(client.BatchPointsConfig{Database: conn.Cfg.Database})
 	if err != nil {
-		slog.Error("could not create batch points", "type", "influx", "name", conn.Name, "error", err)
+		errs.Error("could not create batch points", "type", "influx", "name", conn.Name, "error", err)
 		return
 	}
 	for _, result := range results {
@@ -46,50 +45,51 @@ func SendTestResultsV1Conn(ctx context.Context, cf config.Config, name string, r
 			result.Measurement,
 			result.Tags,
 			result.Fields,
-			time.Now(),
+			result.timestamp(),
 		)
 		if err != nil {
-			slog.Error("could not create point", "type", "influx", "name", conn.Name, "measurement", result.Measurement, "error", err)
+			errs.Error("could not create point", "type", "influx", "name", conn.Name, "measurement", result.Measurement, "error", err)
 		}
 		bp.AddPoint(pt)
 	}
 	if err := conn.Client.Write(bp); err != nil {
-		slog.Error("could not write batch points", "type", "influx", "name", conn.Name, "error", err)
+		errs.Error("could not write batch points", "type", "influx", "name", conn.Name, "error", err)
 	}
 }
 
-func SendTestResultsV2(ctx context.Context, cf config.Config, name string, results []TestResult, wg *sync.WaitGroup) {
+func SendTestResultsV2(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 	points := make([]*write.Point, 0, len(results))
 	for _, result := range results {
 		p := influxdb2.NewPoint(result.Measurement,
 			result.Tags,
 			result.Fields,
-			time.Now())
+			result.timestamp())
 		points = append(points, p)
 	}
 
 	test := cf.Tests[name]
 	conns := ConnectInfluxes2(cf, test.Influxes2)
+	errs.connected("influx2", test.Influxes2, len(conns))
 	defer CloseInfluxes2(conns)
 
 	wg2 := &sync.WaitGroup{}
 	wg2.Add(len(conns))
 	for _, conn := range conns {
-		go SendTestResultsV2Conn(ctx, cf, name, points, conn, wg2)
+		go SendTestResultsV2Conn(ctx, cf, name, points, conn, errs, wg2)
 	}
 	wg2.Wait()
 }
 
-func SendTestResultsV2Conn(ctx context.Context, cf config.Config, name string, points []*write.Point, conn I2ConnV2, wg *sync.WaitGroup) {
+func SendTestResultsV2Conn(ctx context.Context, cf config.Config, name string, points []*write.Point, conn I2ConnV2, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 	err := conn.WriteAPI.WritePoint(ctx, points...)
 	if err != nil {
-		slog.Error("could not write batch points", "type", "influx2", "name", conn.Name, "error", err)
+		errs.Error("could not write batch points", "type", "influx2", "name", conn.Name, "error", err)
 	}
 }
 
-func SendTestResultsV3(ctx context.Context, cf config.Config, name string, results []TestResult, wg *sync.WaitGroup) {
+func SendTestResultsV3(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 	points := make([]*influxdb3.Point, 0, len(results))
 	for _, result := range results {
@@ -97,55 +97,57 @@ func SendTestResultsV3(ctx context.Context, cf config.Config, name string, resul
 			result.Measurement,
 			result.Tags,
 			result.Fields,
-			time.Now(),
+			result.timestamp(),
 		)
 		points = append(points, p)
 	}
 
 	test := cf.Tests[name]
 	conns := ConnectInfluxes3(cf, test.Influxes3)
+	errs.connected("influx3", test.Influxes3, len(conns))
 	defer CloseInfluxes3(conns)
 	wg2 := &sync.WaitGroup{}
 	wg2.Add(len(conns))
 	for _, conn := range conns {
-		go SendTestResultsV3Conn(ctx, cf, name, points, conn, wg2)
+		go SendTestResultsV3Conn(ctx, cf, name, points, conn, errs, wg2)
 	}
 	wg2.Wait()
 }
 
-func SendTestResultsV3Conn(ctx context.Context, cf config.Config, name string, points []*influxdb3.Point, conn I2ConnV3, wg *sync.WaitGroup) {
+func SendTestResultsV3Conn(ctx context.Context, cf config.Config, name string, points []*influxdb3.Point, conn I2ConnV3, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 	err := conn.Conn.WritePoints(ctx, points)
 	if err != nil {
-		slog.Error("could not write batch points", "type", "influx3", "name", conn.Name, "error", err)
+		errs.Error("could not write batch points", "type", "influx3", "name", conn.Name, "error", err)
 	}
 }
 
-func SendTestResultsDb(ctx context.Context, cf config.Config, name string, results []TestResult, wg *sync.WaitGroup) {
+func SendTestResultsDb(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 	test := cf.Tests[name]
 	conns := ConnectDatabases(cf, test.TargetDatabases)
+	errs.connected("database", test.TargetDatabases, len(conns))
 	defer CloseDatabases(conns)
 
 	wg2 := &sync.WaitGroup{}
 	wg2.Add(len(conns))
 	for _, conn := range conns {
-		go SendTestResultsDbConn(ctx, cf, name, results, conn, wg2)
+		go SendTestResultsDbConn(ctx, cf, name, results, conn, errs, wg2)
 	}
 	wg2.Wait()
 }
 
-func SendTestResultsDbConn(ctx context.Context, cf config.Config, name string, results []TestResult, conn I2ConnDb, wg *sync.WaitGroup) {
+func SendTestResultsDbConn(ctx context.Context, cf config.Config, name string, results []TestResult, conn I2ConnDb, errs *SinkErrors, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for _, result := range results {
-		sql, params, err := genInsertSQL(conn.Cfg.InsertSQL, result)
+		sql, params, err := genInsertSQL(conn.Cfg.Driver, conn.Cfg.InsertSQL, result)
 		if err != nil {
-			slog.Error("could not generate insert sql", "type", "database", "name", conn.Name, "error", err)
+			errs.Error("could not generate insert sql", "type", "database", "name", conn.Name, "error", err)
 			continue
 		}
 		_, err = conn.Conn.Exec(sql, params...)
 		if err != nil {
-			slog.Error("could execute insert sql", "type", "database", "name", conn.Name, "error", err)
+			errs.Error("could execute insert sql", "type", "database", "name", conn.Name, "error", err)
 		}
 	}
 }
@@ -153,19 +155,21 @@ func SendTestResultsDbConn(ctx context.Context, cf config.Config, name string, r
 var FieldName = regexp.MustCompile(`^\{FIELDS\[([^\[\]]+)]}$`)
 var TagName = regexp.MustCompile(`^\{TAGS\[([^\[\]]+)]}$`)
 
-func genInsertSQL(sql string, result TestResult) (string, []interface{}, error) {
+func genInsertSQL(driver string, sql string, result TestResult) (string, []interface{}, error) {
 	tokens := SplitIntoTokens(sql)
 	params := make([]interface{}, 0)
 	sql = ""
 	appendParam := func(value interface{}) {
 		params = append(params, value)
-		sql += fmt.Sprintf("$%d", len(params))
+		sql += placeholder(driver, len(params))
 	}
 
 	for _, t := range tokens {
 		// "{MEASUREMENT}"("time",{FIELDNAMES},{TAGNAMES}) VALUES (now(), {FIELDVALUES} ,{TAGVALUES}
 		if t == "{MEASUREMENT}" {
 			appendParam(result.Measurement)
+		} else if t == "{TIME}" {
+			appendParam(result.timestamp())
 		} else if t == "{MEASUREMENT_NAME}" {
 			sql += result.Measurement
 		} else if t == "{FIELDNAMES}" {
@@ -252,3 +256,76 @@ func SplitIntoTokens(input string) []string {
 
 	return tokens
 }
+
+// expandTemplate replaces {name} tokens in tpl with the corresponding values from vars.
+// Unknown tokens are kept as they are.
+func expandTemplate(tpl string, vars map[string]string) string {
+	result := ""
+	for _, t := range SplitIntoTokens(tpl) {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			value, ok := vars[t[1:len(t)-1]]
+			if ok {
+				result += value
+				continue
+			}
+		}
+		result += t
+	}
+	return result
+}
+
+// resultVars returns the template variables of a test result: {test}, {measurement} and {tags.NAME}
+// for each tag.
+func resultVars(name string, result TestResult) map[string]string {
+	vars := map[string]string{
+		"test":        name,
+		"measurement": result.Measurement,
+	}
+	for tag, value := range result.Tags {
+		vars["tags."+tag] = value
+	}
+	return vars
+}
+
+// toFloat64 converts a numeric field value into a float64. Booleans are converted to 0/1.
+// The second return value is false for non-numeric values.
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case []byte:
+		f, err := strconv.ParseFloat(string(v), 64)
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
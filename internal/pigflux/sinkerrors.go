@@ -0,0 +1,39 @@
+package pigflux
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// SinkErrors collects the errors of the sinks of a test run, so that the run can tell whether all sinks
+// accepted the results.
+type SinkErrors struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// Error logs an error of a sink, and records it.
+func (e *SinkErrors) Error(msg string, args ...any) {
+	slog.Error(msg, args...)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, errors.New(msg))
+}
+
+// connected records an error when some of the named sinks could not be connected.
+func (e *SinkErrors) connected(sinkType string, names []string, count int) {
+	if count < len(names) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.errs = append(e.errs, fmt.Errorf("%d of %d %s sink(s) could not be connected", len(names)-count, len(names), sinkType))
+	}
+}
+
+// Err returns the collected errors, or nil when all sinks succeeded.
+func (e *SinkErrors) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return errors.Join(e.errs...)
+}
@@ -0,0 +1,154 @@
+package pigflux
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// sqliteDatabase creates a SQLite database file with the given statements, and returns its config.
+func sqliteDatabase(t *testing.T, statements ...string) config.Database {
+	t.Helper()
+	db := config.Database{Driver: "sqlite", DSN: "file:" + filepath.Join(t.TempDir(), "test.db") + "?_time_format=sqlite"}
+	conn, err := sql.Open(db.Driver, db.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, statement := range statements {
+		if _, err := conn.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	return db
+}
+
+func TestSqliteFetch(t *testing.T) {
+	db := sqliteDatabase(t,
+		"CREATE TABLE t (name TEXT, size INTEGER, ratio REAL)",
+		"INSERT INTO t VALUES ('a', 1, 0.5), ('b', 2, 1.5)",
+	)
+	test := config.Test{SQL: "SELECT name, size, ratio FROM t ORDER BY name", Fields: []string{"size", "ratio"}}
+	cf := config.Config{Databases: map[string]config.Database{"db": db}, Tests: map[string]config.Test{"test": test}}
+	results, err := fetchTest(cf, "db", "test", test, Pass{Start: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for i, want := range []struct {
+		name  string
+		size  int64
+		ratio float64
+	}{{"a", 1, 0.5}, {"b", 2, 1.5}} {
+		r := results[i]
+		if r.Tags["name"] != want.name || r.Fields["size"] != want.size || r.Fields["ratio"] != want.ratio || len(r.Tags) != 1 {
+			t.Errorf("result %d is %+v, want %+v", i, r, want)
+		}
+	}
+
+	// fields that are missing from the result are reported
+	test.Fields = []string{"size", "missing"}
+	if _, err := fetchTest(cf, "db", "test", test, Pass{Start: time.Now()}); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+}
+
+func sendSqlite(t *testing.T, db config.Database, results []TestResult) {
+	t.Helper()
+	cf := config.Config{
+		Databases: map[string]config.Database{"target": db},
+		Tests:     map[string]config.Test{"test": {TargetDatabases: []string{"target"}}},
+	}
+	errs := &SinkErrors{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	SendTestResultsDb(context.Background(), cf, "test", results, errs, wg)
+	wg.Wait()
+	if err := errs.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var sqliteResults = []TestResult{{
+	Measurement: "db",
+	Fields:      map[string]interface{}{"size": int64(42), "ratio": 0.5},
+	Tags:        map[string]string{"host": "h1", "env": "prod"},
+	Time:        time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+}}
+
+func TestSqliteInsertNamedValues(t *testing.T) {
+	db := sqliteDatabase(t, "CREATE TABLE results (measurement TEXT, time TIMESTAMP, size INTEGER, host TEXT, tags TEXT)")
+	db.InsertSQL = "INSERT INTO results VALUES ({MEASUREMENT}, {TIME}, {FIELDS[size]}, {TAGS[host]}, {TAGS_JSON})"
+	sendSqlite(t, db, sqliteResults)
+
+	conn, err := sql.Open(db.Driver, db.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var measurement, host, tags string
+	var ts time.Time
+	var size int64
+	err = conn.QueryRow("SELECT measurement, time, size, host, tags FROM results").Scan(&measurement, &ts, &size, &host, &tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if measurement != "db" || !ts.Equal(sqliteResults[0].Time) || size != 42 || host != "h1" || tags != `{"env":"prod","host":"h1"}` {
+		t.Errorf("got %s %v %d %s %s", measurement, ts, size, host, tags)
+	}
+}
+
+func TestSqliteInsertColumnLists(t *testing.T) {
+	db := sqliteDatabase(t, "CREATE TABLE db (ratio REAL, size INTEGER, env TEXT, host TEXT)")
+	db.InsertSQL = "INSERT INTO {MEASUREMENT_NAME} ({FIELDNAMES},{TAGNAMES}) VALUES ({FIELDVALUES},{TAGVALUES})"
+	query, params, err := genInsertSQL(db.Driver, db.InsertSQL, sqliteResults[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "INSERT INTO db (ratio,size,env,host) VALUES (?,?,?,?)"; query != want {
+		t.Errorf("got %q, want %q", query, want)
+	}
+	if len(params) != 4 {
+		t.Errorf("got params %v, want 4", params)
+	}
+	sendSqlite(t, db, sqliteResults)
+
+	conn, err := sql.Open(db.Driver, db.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var ratio float64
+	var size int64
+	var env, host string
+	if err := conn.QueryRow("SELECT ratio, size, env, host FROM db").Scan(&ratio, &size, &env, &host); err != nil {
+		t.Fatal(err)
+	}
+	if ratio != 0.5 || size != 42 || env != "prod" || host != "h1" {
+		t.Errorf("got %v %d %s %s", ratio, size, env, host)
+	}
+}
+
+func TestGenInsertSQLPlaceholders(t *testing.T) {
+	for driver, want := range map[string]string{
+		"sqlite":    "INSERT INTO t VALUES (?, ?)",
+		"mysql":     "INSERT INTO t VALUES (?, ?)",
+		"pgx":       "INSERT INTO t VALUES ($1, $2)",
+		"sqlserver": "INSERT INTO t VALUES (@p1, @p2)",
+	} {
+		query, _, err := genInsertSQL(driver, "INSERT INTO t VALUES ({MEASUREMENT}, {FIELDS[size]})", sqliteResults[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != want {
+			t.Errorf("%s: got %q, want %q", driver, query, want)
+		}
+	}
+}
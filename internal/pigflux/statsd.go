@@ -0,0 +1,144 @@
+package pigflux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+type I2ConnStatsd struct {
+	Cfg  config.Statsd
+	Name string
+	Conn net.Conn
+}
+
+func ConnectStatsds(cf config.Config, names []string) []I2ConnStatsd {
+	conns := make([]I2ConnStatsd, 0)
+	for _, name := range names {
+		scfg := cf.Statsds[name]
+		conn, err := net.Dial("udp", scfg.Address)
+		if err != nil {
+			slog.Error("could not create statsd connection", "name", name, "error", err)
+			continue
+		}
+		conns = append(conns, I2ConnStatsd{Cfg: scfg, Name: name, Conn: conn})
+	}
+	return conns
+}
+
+func CloseStatsds(conns []I2ConnStatsd) {
+	for _, cl := range conns {
+		err := cl.Conn.Close()
+		if err != nil {
+			slog.Error("could not close statsd connection", "name", cl.Name, "error", err)
+		}
+	}
+}
+
+func SendTestResultsStatsd(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	test := cf.Tests[name]
+	conns := ConnectStatsds(cf, test.Statsds)
+	errs.connected("statsd", test.Statsds, len(conns))
+	defer CloseStatsds(conns)
+
+	wg2 := &sync.WaitGroup{}
+	wg2.Add(len(conns))
+	for _, conn := range conns {
+		go SendTestResultsStatsdConn(ctx, cf, name, results, conn, errs, wg2)
+	}
+	wg2.Wait()
+}
+
+func SendTestResultsStatsdConn(ctx context.Context, cf config.Config, name string, results []TestResult, conn I2ConnStatsd, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	packet := ""
+	flush := func() {
+		if packet == "" {
+			return
+		}
+		_, err := conn.Conn.Write([]byte(packet))
+		if err != nil {
+			errs.Error("could not write statsd packet", "type", "statsd", "name", conn.Name, "error", err)
+		}
+		packet = ""
+	}
+	for _, result := range results {
+		for _, line := range statsdLines(conn.Cfg, result) {
+			if len(line) > conn.Cfg.MaxPacketSize {
+				// the result is not (completely) sent, so it is not accepted by the sink
+				errs.Error("statsd metric does not fit into a single packet, skipping",
+					"type", "statsd", "name", conn.Name, "measurement", result.Measurement, "size", len(line))
+				continue
+			}
+			if packet != "" && len(packet)+1+len(line) > conn.Cfg.MaxPacketSize {
+				flush()
+			}
+			if packet != "" {
+				packet += "\n"
+			}
+			packet += line
+		}
+	}
+	flush()
+}
+
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "=", "_", ";", "_", "\n", "_")
+
+// statsdLines converts a test result into statsd gauges, one for each numeric field. StatsD reads a signed
+// gauge value as a change of the gauge, so a negative value is sent as two lines: the gauge is set to zero first,
+// then the negative value is applied. These lines are returned together, so they are sent in the same packet.
+func statsdLines(cfg config.Statsd, result TestResult) []string {
+	lines := make([]string, 0, len(result.Fields))
+	tagNames := slices.Sorted(maps.Keys(result.Tags))
+	for _, field := range slices.Sorted(maps.Keys(result.Fields)) {
+		value, ok := toFloat64(result.Fields[field])
+		if !ok {
+			slog.Debug("skipping non-numeric field for statsd", "measurement", result.Measurement, "field", field)
+			continue
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			slog.Debug("skipping non-finite field for statsd", "measurement", result.Measurement, "field", field, "value", value)
+			continue
+		}
+		metric := cfg.Prefix + expandTemplate(cfg.MetricName, map[string]string{
+			"measurement": result.Measurement,
+			"field":       field,
+		})
+		metric = statsdReplacer.Replace(metric)
+		suffix := ""
+		switch cfg.TagFormat {
+		case "dogstatsd":
+			tags := make([]string, 0, len(tagNames))
+			for _, tag := range tagNames {
+				tags = append(tags, statsdReplacer.Replace(tag)+":"+statsdReplacer.Replace(result.Tags[tag]))
+			}
+			if len(tags) > 0 {
+				suffix = "|#" + strings.Join(tags, ",")
+			}
+		case "influx", "graphite":
+			sep := ","
+			if cfg.TagFormat == "graphite" {
+				sep = ";"
+			}
+			for _, tag := range tagNames {
+				metric += fmt.Sprintf("%s%s=%s", sep, statsdReplacer.Replace(tag), statsdReplacer.Replace(result.Tags[tag]))
+			}
+		}
+		line := metric + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|g" + suffix
+		if value < 0 {
+			line = metric + ":0|g" + suffix + "\n" + line
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,155 @@
+package pigflux
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// listenStatsd starts a local UDP listener, and returns its address and a function that returns the packets
+// received until the listener is idle.
+func listenStatsd(t *testing.T) (string, func() []string) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	receive := func() []string {
+		packets := make([]string, 0)
+		buf := make([]byte, 65536)
+		for {
+			_ = pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := pc.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return packets
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets = append(packets, string(buf[:n]))
+		}
+	}
+	return pc.LocalAddr().String(), receive
+}
+
+func sendStatsd(t *testing.T, cfg config.Statsd, results []TestResult) []string {
+	t.Helper()
+	packets, err := sendStatsdErr(t, cfg, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packets
+}
+
+// sendStatsdErr sends the results to a local listener, and returns the received packets and the sink errors.
+func sendStatsdErr(t *testing.T, cfg config.Statsd, results []TestResult) ([]string, error) {
+	t.Helper()
+	addr, receive := listenStatsd(t)
+	cfg.Address = addr
+	if cfg.MetricName == "" {
+		cfg.MetricName = "{measurement}.{field}"
+	}
+	if cfg.MaxPacketSize == 0 {
+		cfg.MaxPacketSize = 1432
+	}
+	cf := config.Config{
+		Statsds: map[string]config.Statsd{"sd": cfg},
+		Tests:   map[string]config.Test{"test": {Statsds: []string{"sd"}}},
+	}
+	errs := &SinkErrors{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	SendTestResultsStatsd(context.Background(), cf, "test", results, errs, wg)
+	wg.Wait()
+	return receive(), errs.Err()
+}
+
+func TestStatsdTagFormats(t *testing.T) {
+	result := TestResult{
+		Measurement: "db",
+		Fields:      map[string]interface{}{"size": int64(42), "name": "not a number"},
+		Tags:        map[string]string{"host": "h1", "env": "prod"},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"dogstatsd", "pf.db.size:42|g|#env:prod,host:h1"},
+		{"influx", "pf.db.size,env=prod,host=h1:42|g"},
+		{"graphite", "pf.db.size;env=prod;host=h1:42|g"},
+		{"none", "pf.db.size:42|g"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			packets := sendStatsd(t, config.Statsd{Prefix: "pf.", TagFormat: tt.format}, []TestResult{result})
+			if len(packets) != 1 || packets[0] != tt.want {
+				t.Errorf("got %q, want %q", packets, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsdNegativeGauge(t *testing.T) {
+	result := TestResult{Measurement: "m", Fields: map[string]interface{}{"delta": -5.5}, Tags: map[string]string{"a": "b"}}
+	packets := sendStatsd(t, config.Statsd{TagFormat: "dogstatsd"}, []TestResult{result})
+	want := "m.delta:0|g|#a:b\nm.delta:-5.5|g|#a:b"
+	if len(packets) != 1 || packets[0] != want {
+		t.Errorf("got %q, want %q", packets, want)
+	}
+}
+
+func TestStatsdNonFinite(t *testing.T) {
+	result := TestResult{Measurement: "m", Tags: map[string]string{}, Fields: map[string]interface{}{
+		"nan": math.NaN(), "inf": math.Inf(1), "neginf": math.Inf(-1), "ok": 1.5,
+	}}
+	packets := sendStatsd(t, config.Statsd{TagFormat: "none"}, []TestResult{result})
+	if len(packets) != 1 || packets[0] != "m.ok:1.5|g" {
+		t.Errorf("got %q, want only the finite value", packets)
+	}
+}
+
+func TestStatsdPacketSplitting(t *testing.T) {
+	results := make([]TestResult, 0)
+	for _, m := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		results = append(results, TestResult{Measurement: m, Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{}})
+	}
+	// each line is "mN.f:1|g" (8 bytes), two lines and a newline fit into 20 bytes
+	packets := sendStatsd(t, config.Statsd{TagFormat: "none", MaxPacketSize: 20}, results)
+	for _, packet := range packets {
+		if len(packet) > 20 {
+			t.Errorf("packet is larger than max_packet_size: %q", packet)
+		}
+	}
+	lines := strings.Split(strings.Join(packets, "\n"), "\n")
+	want := []string{"m1.f:1|g", "m2.f:1|g", "m3.f:1|g", "m4.f:1|g", "m5.f:1|g"}
+	if !slices.Equal(lines, want) || len(packets) != 3 {
+		t.Errorf("got %d packets %q, want 3 packets with %q", len(packets), packets, want)
+	}
+
+	// lines that do not fit into a packet are skipped, and reported as not sent
+	long := TestResult{Measurement: strings.Repeat("x", 30), Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{}}
+	packets, err := sendStatsdErr(t, config.Statsd{TagFormat: "none", MaxPacketSize: 20}, []TestResult{long, results[0]})
+	if len(packets) != 1 || packets[0] != "m1.f:1|g" {
+		t.Errorf("got %q, want only the line that fits", packets)
+	}
+	if err == nil {
+		t.Error("a skipped line is not an error of the sink")
+	}
+
+	// the zero reset and the negative value stay in the same packet
+	negative := TestResult{Measurement: "n", Fields: map[string]interface{}{"f": -1}, Tags: map[string]string{}}
+	packets = sendStatsd(t, config.Statsd{TagFormat: "none", MaxPacketSize: 20}, []TestResult{results[0], negative})
+	if len(packets) != 2 || packets[1] != "n.f:0|g\nn.f:-1|g" {
+		t.Errorf("got %q, want the negative gauge in its own packet", packets)
+	}
+}
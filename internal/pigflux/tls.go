@@ -0,0 +1,65 @@
+package pigflux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// newTLSConfig creates a client side TLS configuration. It returns nil when nothing is configured,
+// so the default settings of the underlying client will be used.
+func newTLSConfig(cfg config.TLS) (*tls.Config, error) {
+	if cfg == (config.TLS{}) {
+		return nil, nil
+	}
+	result := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file %s: %w", cfg.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		result.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate %s: %w", cfg.CertFile, err)
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	return result, nil
+}
+
+// newHTTPClient creates a HTTP client with the given TLS settings and timeout.
+func newHTTPClient(cfg config.TLS, timeout time.Duration) (*http.Client, error) {
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsCfg != nil {
+		transport.TLSClientConfig = tlsCfg
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// setHTTPAuth adds basic or bearer token authentication to the request, when configured.
+func setHTTPAuth(req *http.Request, username, password, bearerToken string) {
+	if bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	} else if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+}
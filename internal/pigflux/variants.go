@@ -0,0 +1,92 @@
+package pigflux
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+// serverVersions caches the detected server versions, keyed by DSN. Versions are detected again after
+// serverVersionTTL, or after a query of the database failed, because the server may have been upgraded.
+var serverVersions sync.Map
+
+const serverVersionTTL = 10 * time.Minute
+
+type detectedVersion struct {
+	Flavor   string
+	Version  string
+	Detected time.Time
+}
+
+// versionSQL returns the query that tells the server version, for each driver.
+var versionSQL = map[string]string{
+	"pgx":       "SHOW server_version",
+	"mysql":     "SELECT VERSION()",
+	"sqlserver": "SELECT CAST(SERVERPROPERTY('ProductVersion') AS nvarchar(128))",
+	"sqlite":    "SELECT sqlite_version()",
+}
+
+// serverVersion detects the flavor and the version of the database server.
+func serverVersion(conn *sql.DB, db config.Database) (detectedVersion, error) {
+	if cached, ok := serverVersions.Load(db.DSN); ok {
+		dv := cached.(detectedVersion)
+		if time.Since(dv.Detected) < serverVersionTTL {
+			return dv, nil
+		}
+	}
+	query, ok := versionSQL[db.Driver]
+	if !ok {
+		return detectedVersion{}, fmt.Errorf("cannot detect server version for driver %s", db.Driver)
+	}
+	var version string
+	err := conn.QueryRow(query).Scan(&version)
+	if err != nil {
+		return detectedVersion{}, fmt.Errorf("cannot detect server version: %w", err)
+	}
+	dv := parseServerVersion(db.Driver, version)
+	dv.Detected = time.Now()
+	serverVersions.Store(db.DSN, dv)
+	return dv, nil
+}
+
+// parseServerVersion tells the flavor of the server from its version. MariaDB reports versions like
+// "10.11.6-MariaDB-log", or "5.5.5-10.11.6-MariaDB" through old proxies.
+func parseServerVersion(driver string, version string) detectedVersion {
+	if driver == "mysql" && strings.Contains(strings.ToLower(version), "mariadb") {
+		return detectedVersion{Flavor: config.FlavorMariaDB, Version: strings.TrimPrefix(version, "5.5.5-")}
+	}
+	return detectedVersion{Version: version}
+}
+
+// forgetServerVersion drops the cached server version, so that it is detected again for the next query.
+func forgetServerVersion(db config.Database) {
+	serverVersions.Delete(db.DSN)
+}
+
+// testSQL selects the query of the test for the database. The first matching variant is used, the sql of the
+// test is the fallback when no variant matches the server version.
+func testSQL(conn *sql.DB, db config.Database, dbname string, test config.Test) (string, error) {
+	if len(test.Variants) == 0 {
+		return test.SQL, nil
+	}
+	dv, err := serverVersion(conn, db)
+	if err != nil {
+		return "", err
+	}
+	for _, variant := range test.Variants {
+		if variant.Matches(dv.Flavor, dv.Version) {
+			slog.Debug("using query variant", "dbname", dbname, "flavor", dv.Flavor, "version", dv.Version,
+				"min_version", variant.MinVersion, "max_version", variant.MaxVersion)
+			return variant.SQL, nil
+		}
+	}
+	if test.SQL == "" {
+		return "", fmt.Errorf("no query for server version %s of database %s", dv.Version, dbname)
+	}
+	return test.SQL, nil
+}
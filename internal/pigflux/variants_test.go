@@ -0,0 +1,94 @@
+package pigflux
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	tests := []struct {
+		driver  string
+		version string
+		want    detectedVersion
+	}{
+		{"mysql", "8.0.35-log", detectedVersion{Version: "8.0.35-log"}},
+		{"mysql", "10.11.6-MariaDB-log", detectedVersion{Flavor: "mariadb", Version: "10.11.6-MariaDB-log"}},
+		{"mysql", "5.5.5-10.6.16-MariaDB", detectedVersion{Flavor: "mariadb", Version: "10.6.16-MariaDB"}},
+		{"pgx", "16.4 (Debian 16.4-1.pgdg120+1)", detectedVersion{Version: "16.4 (Debian 16.4-1.pgdg120+1)"}},
+	}
+	for _, tt := range tests {
+		if got := parseServerVersion(tt.driver, tt.version); got != tt.want {
+			t.Errorf("%s %s: got %+v, want %+v", tt.driver, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestVariantFlavors(t *testing.T) {
+	variants := []config.SQLVariant{
+		{Flavor: "mariadb", MinVersion: "10.5", SQL: "mariadb"},
+		{MinVersion: "5.7", SQL: "mysql"},
+	}
+	for _, tt := range []struct {
+		version string
+		want    string
+	}{
+		{"8.0.35", "mysql"},
+		{"5.6.51", ""},
+		{"10.11.6-MariaDB", "mariadb"},
+		{"10.4.32-MariaDB", ""},
+	} {
+		dv := parseServerVersion("mysql", tt.version)
+		got := ""
+		for _, variant := range variants {
+			if variant.Matches(dv.Flavor, dv.Version) {
+				got = variant.SQL
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: got variant %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestServerVersionCache(t *testing.T) {
+	db := sqliteDatabase(t)
+	conn, err := sql.Open(db.Driver, db.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	defer forgetServerVersion(db)
+
+	dv, err := serverVersion(conn, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dv.Version == "" || dv.Flavor != "" {
+		t.Fatalf("unexpected version %+v", dv)
+	}
+
+	// a cached version is used until it expires
+	serverVersions.Store(db.DSN, detectedVersion{Version: "1.0", Detected: time.Now()})
+	if dv, _ := serverVersion(conn, db); dv.Version != "1.0" {
+		t.Errorf("got %s, want the cached version", dv.Version)
+	}
+	serverVersions.Store(db.DSN, detectedVersion{Version: "1.0", Detected: time.Now().Add(-serverVersionTTL)})
+	if dv, _ := serverVersion(conn, db); dv.Version == "1.0" {
+		t.Error("an expired version should be detected again")
+	}
+
+	// a failed query drops the cached version
+	serverVersions.Store(db.DSN, detectedVersion{Version: "1.0", Detected: time.Now()})
+	test := config.Test{SQL: "SELECT x FROM missing", Fields: []string{"x"}, Variants: []config.SQLVariant{{MinVersion: "1", SQL: "SELECT x FROM missing"}}}
+	cf := config.Config{Databases: map[string]config.Database{"db": db}}
+	if _, err := fetchTest(cf, "db", "test", test, Pass{Start: time.Now()}); err == nil {
+		t.Fatal("expected a query error")
+	}
+	if _, ok := serverVersions.Load(db.DSN); ok {
+		t.Error("the cached version should be dropped after a failed query")
+	}
+}
@@ -0,0 +1,61 @@
+package pigflux
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/nagylzs/pigflux/internal/state"
+)
+
+// stateStore keeps the watermarks of incremental tests. It is kept in memory until SetStateStore is called.
+var stateStore, _ = state.Open("")
+
+// SetStateStore sets the state store that keeps the watermarks of incremental tests.
+func SetStateStore(store *state.Store) {
+	stateStore = store
+}
+
+// watermarkValue returns the watermark of the test on the database, or its initial value before the first run.
+func watermarkValue(test config.Test, testName string, dbname string, now time.Time) (interface{}, error) {
+	if v, ok := stateStore.Watermark(testName, dbname); ok {
+		return v.Any()
+	}
+	return test.Watermark.Initial.Resolve(now)
+}
+
+// maxWatermark returns the maximum watermark value of the fetched rows. Rows without a value are skipped.
+func maxWatermark(test config.Test, results []FetchResult) (state.Value, bool, error) {
+	var result state.Value
+	found := false
+	for _, fr := range results {
+		if fr.Watermark == nil {
+			continue
+		}
+		v, err := state.NewValue(fr.Watermark)
+		if err != nil {
+			return result, false, fmt.Errorf("invalid watermark column %s: %w", test.Watermark.Column, err)
+		}
+		if !found || result.Less(v) {
+			result = v
+			found = true
+		}
+	}
+	return result, found, nil
+}
+
+// commitWatermarks stores the new watermarks of a test, when all sinks accepted the results.
+func commitWatermarks(testName string, watermarks map[string]state.Value, errs *SinkErrors) {
+	if len(watermarks) == 0 {
+		return
+	}
+	if err := errs.Err(); err != nil {
+		slog.Warn(fmt.Sprintf("Not all sinks accepted the results of test %s, the watermark is not advanced", testName))
+		return
+	}
+	err := stateStore.SetWatermarks(testName, watermarks)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not store the watermark of test %s: %v", testName, err))
+	}
+}
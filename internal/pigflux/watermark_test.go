@@ -0,0 +1,102 @@
+package pigflux
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+	"github.com/nagylzs/pigflux/internal/state"
+)
+
+// useStateStore sets a file backed state store for the test, and restores the previous one after the test.
+func useStateStore(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := stateStore
+	SetStateStore(store)
+	t.Cleanup(func() { SetStateStore(previous) })
+	return path
+}
+
+func watermarkConfig(t *testing.T, db config.Database, webhookURL string) config.Config {
+	t.Helper()
+	return config.Config{
+		Databases: map[string]config.Database{"db": db},
+		Webhooks:  map[string]config.Webhook{"wh": {URL: webhookURL, Method: "POST", Timeout: 5 * time.Second}},
+		Tests: map[string]config.Test{"events": {
+			Databases: []string{"db"}, Webhooks: []string{"wh"}, Measurement: "events",
+			SQL:       "SELECT count(*) AS cnt, max(id) AS last_id FROM events WHERE id > {PARAMS[watermark]}",
+			Fields:    []string{"cnt"},
+			Watermark: config.Watermark{Column: "last_id", Initial: config.Param{Type: config.ParamInt, Value: "0"}},
+		}},
+	}
+}
+
+func TestWatermarkFailedSink(t *testing.T) {
+	path := useStateStore(t)
+	db := sqliteDatabase(t, "CREATE TABLE events (id INTEGER)", "INSERT INTO events VALUES (1), (2), (3)")
+
+	// the first run is accepted, the watermark is stored
+	ok, _ := webhookServer(t, http.StatusOK)
+	if err := RunTest(watermarkConfig(t, db, ok.URL), "events", Pass{Start: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	v, found := stateStore.Watermark("events", "db")
+	if !found || v.Value != "3" {
+		t.Fatalf("got watermark %v, want 3", v)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new rows are not accepted by the sink, the watermark and the state file are unchanged
+	conn, err := sql.Open(db.Driver, db.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec("INSERT INTO events VALUES (4), (5)"); err != nil {
+		t.Fatal(err)
+	}
+	failing, received := webhookServer(t, http.StatusInternalServerError)
+	if err := RunTest(watermarkConfig(t, db, failing.URL), "events", Pass{Start: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if len(received()) != 1 {
+		t.Fatalf("got %d webhook requests, want 1", len(received()))
+	}
+	if v, _ := stateStore.Watermark("events", "db"); v.Value != "3" {
+		t.Errorf("got watermark %v after a failed sink, want 3", v.Value)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(saved) {
+		t.Errorf("the state file is changed after a failed sink:\n%s\nwant\n%s", after, saved)
+	}
+
+	// the rows are sent again on the next run, and the watermark is advanced
+	if err := RunTest(watermarkConfig(t, db, ok.URL), "events", Pass{Start: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := stateStore.Watermark("events", "db"); v.Value != "5" {
+		t.Errorf("got watermark %v, want 5", v.Value)
+	}
+	store, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.Watermark("events", "db"); v.Value != "5" {
+		t.Errorf("got watermark %v in the state file, want 5", v.Value)
+	}
+}
@@ -0,0 +1,150 @@
+package pigflux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+type I2ConnWebhook struct {
+	Cfg      config.Webhook
+	Name     string
+	Client   *http.Client
+	Template *template.Template
+}
+
+// webhookPoint is the template data for a single test result.
+type webhookPoint struct {
+	Test        string                 `json:"test"`
+	Time        time.Time              `json:"time"`
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+}
+
+// webhookBatch is the template data when all test results are sent in a single request.
+type webhookBatch struct {
+	Test    string
+	Time    time.Time
+	Results []webhookPoint
+}
+
+func ConnectWebhooks(cf config.Config, names []string) []I2ConnWebhook {
+	conns := make([]I2ConnWebhook, 0)
+	for _, name := range names {
+		wcfg := cf.Webhooks[name]
+		cl, err := newHTTPClient(wcfg.TLS, wcfg.Timeout)
+		if err != nil {
+			slog.Error("could not create webhook client", "name", name, "error", err)
+			continue
+		}
+		conns = append(conns, I2ConnWebhook{Cfg: wcfg, Name: name, Client: cl, Template: wcfg.BodyTemplate})
+	}
+	return conns
+}
+
+func CloseWebhooks(conns []I2ConnWebhook) {
+	for _, cl := range conns {
+		cl.Client.CloseIdleConnections()
+	}
+}
+
+func SendTestResultsWebhook(ctx context.Context, cf config.Config, name string, results []TestResult, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	test := cf.Tests[name]
+	conns := ConnectWebhooks(cf, test.Webhooks)
+	errs.connected("webhook", test.Webhooks, len(conns))
+	defer CloseWebhooks(conns)
+
+	wg2 := &sync.WaitGroup{}
+	wg2.Add(len(conns))
+	for _, conn := range conns {
+		go SendTestResultsWebhookConn(ctx, cf, name, results, conn, errs, wg2)
+	}
+	wg2.Wait()
+}
+
+func SendTestResultsWebhookConn(ctx context.Context, cf config.Config, name string, results []TestResult, conn I2ConnWebhook, errs *SinkErrors, wg *sync.WaitGroup) {
+	defer wg.Done()
+	now := resultsTime(results)
+	points := make([]webhookPoint, 0, len(results))
+	for _, result := range results {
+		points = append(points, webhookPoint{
+			Test:        name,
+			Time:        now,
+			Measurement: result.Measurement,
+			Tags:        result.Tags,
+			Fields:      result.Fields,
+		})
+	}
+	if conn.Cfg.Batch {
+		if len(points) == 0 {
+			return
+		}
+		err := postWebhook(ctx, conn, webhookBatch{Test: name, Time: now, Results: points})
+		if err != nil {
+			errs.Error("could not send webhook", "type", "webhook", "name", conn.Name, "error", err)
+		}
+		return
+	}
+	for _, point := range points {
+		err := postWebhook(ctx, conn, point)
+		if err != nil {
+			errs.Error("could not send webhook", "type", "webhook", "name", conn.Name, "measurement", point.Measurement, "error", err)
+		}
+	}
+}
+
+// webhookBody renders the body template. Without a template, the data is sent as JSON (a single object
+// for each point, or an array of objects for batches).
+func webhookBody(conn I2ConnWebhook, data interface{}) ([]byte, error) {
+	if conn.Template == nil {
+		if batch, ok := data.(webhookBatch); ok {
+			data = batch.Results
+		}
+		return json.Marshal(data)
+	}
+	buf := &bytes.Buffer{}
+	err := conn.Template.Execute(buf, data)
+	return buf.Bytes(), err
+}
+
+func postWebhook(ctx context.Context, conn I2ConnWebhook, data interface{}) error {
+	body, err := webhookBody(conn, data)
+	if err != nil {
+		return fmt.Errorf("cannot render body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, conn.Cfg.Method, conn.Cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range conn.Cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	setHTTPAuth(req, conn.Cfg.Username, conn.Cfg.Password, conn.Cfg.BearerToken)
+	resp, err := conn.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if len(conn.Cfg.SuccessCodes) > 0 {
+		ok = slices.Contains(conn.Cfg.SuccessCodes, resp.StatusCode)
+	}
+	if !ok {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package pigflux
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/nagylzs/pigflux/internal/config"
+)
+
+type webhookRequest struct {
+	Method string
+	Header http.Header
+	Body   string
+}
+
+// webhookServer starts a test server that answers with the given status, and returns the received requests.
+func webhookServer(t *testing.T, status int) (*httptest.Server, func() []webhookRequest) {
+	t.Helper()
+	mu := sync.Mutex{}
+	received := make([]webhookRequest, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, webhookRequest{Method: r.Method, Header: r.Header, Body: string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+}
+
+func sendWebhook(t *testing.T, wh config.Webhook, results []TestResult) error {
+	t.Helper()
+	cf := config.Config{
+		Webhooks: map[string]config.Webhook{"wh": wh},
+		Tests:    map[string]config.Test{"test": {Webhooks: []string{"wh"}}},
+	}
+	for name, wh := range cf.Webhooks {
+		if wh.Method == "" {
+			wh.Method = "POST"
+		}
+		if wh.Timeout == 0 {
+			wh.Timeout = 5 * time.Second
+		}
+		cf.Webhooks[name] = wh
+	}
+	errs := &SinkErrors{}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	SendTestResultsWebhook(context.Background(), cf, "test", results, errs, wg)
+	wg.Wait()
+	return errs.Err()
+}
+
+var webhookResults = []TestResult{
+	{Measurement: "m1", Fields: map[string]interface{}{"f": 1}, Tags: map[string]string{"t": "a"}},
+	{Measurement: "m2", Fields: map[string]interface{}{"f": 2}, Tags: map[string]string{"t": "b"}},
+}
+
+func TestWebhookPoints(t *testing.T) {
+	srv, received := webhookServer(t, http.StatusOK)
+	err := sendWebhook(t, config.Webhook{
+		URL:         srv.URL,
+		Method:      "PUT",
+		Headers:     map[string]string{"X-Source": "pigflux"},
+		BearerToken: "TOKEN",
+	}, webhookResults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := received()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want one for each point", len(reqs))
+	}
+	measurements := make(map[string]bool)
+	for _, req := range reqs {
+		if req.Method != "PUT" {
+			t.Errorf("method is %s, want PUT", req.Method)
+		}
+		if got := req.Header.Get("Authorization"); got != "Bearer TOKEN" {
+			t.Errorf("authorization is %q", got)
+		}
+		if got := req.Header.Get("X-Source"); got != "pigflux" {
+			t.Errorf("X-Source is %q", got)
+		}
+		if got := req.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("content type is %q", got)
+		}
+		point := webhookPoint{}
+		if err := json.Unmarshal([]byte(req.Body), &point); err != nil {
+			t.Fatalf("invalid body %q: %v", req.Body, err)
+		}
+		if point.Test != "test" || len(point.Fields) != 1 || len(point.Tags) != 1 {
+			t.Errorf("unexpected point %+v", point)
+		}
+		measurements[point.Measurement] = true
+	}
+	if !measurements["m1"] || !measurements["m2"] {
+		t.Errorf("got measurements %v, want m1 and m2", measurements)
+	}
+}
+
+func TestWebhookBatchTemplate(t *testing.T) {
+	srv, received := webhookServer(t, http.StatusOK)
+	body := `{{range .Results}}{{.Measurement}}={{json .Fields}};{{end}}`
+	tpl, err := template.New("wh").Funcs(config.WebhookFuncs).Parse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sendWebhook(t, config.Webhook{URL: srv.URL, Batch: true, Body: body, BodyTemplate: tpl}, webhookResults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := received()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want a single batch", len(reqs))
+	}
+	if want := `m1={"f":1};m2={"f":2};`; reqs[0].Body != want {
+		t.Errorf("body is %q, want %q", reqs[0].Body, want)
+	}
+}
+
+func TestWebhookBatchJSON(t *testing.T) {
+	srv, received := webhookServer(t, http.StatusOK)
+	if err := sendWebhook(t, config.Webhook{URL: srv.URL, Batch: true}, webhookResults); err != nil {
+		t.Fatal(err)
+	}
+	reqs := received()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want a single batch", len(reqs))
+	}
+	points := make([]webhookPoint, 0)
+	if err := json.Unmarshal([]byte(reqs[0].Body), &points); err != nil || len(points) != 2 {
+		t.Errorf("body %q is not a list of 2 points: %v", reqs[0].Body, err)
+	}
+}
+
+func TestWebhookStatus(t *testing.T) {
+	srv, _ := webhookServer(t, http.StatusAccepted)
+	if err := sendWebhook(t, config.Webhook{URL: srv.URL, Batch: true}, webhookResults); err != nil {
+		t.Errorf("2xx should be accepted by default: %v", err)
+	}
+	err := sendWebhook(t, config.Webhook{URL: srv.URL, Batch: true, SuccessCodes: []int{200}}, webhookResults)
+	if err == nil {
+		t.Error("expected an error for status 202 when only 200 is a success code")
+	}
+	srv, _ = webhookServer(t, http.StatusInternalServerError)
+	if err := sendWebhook(t, config.Webhook{URL: srv.URL, Batch: true}, webhookResults); err == nil {
+		t.Error("expected an error for status 500")
+	}
+}
@@ -0,0 +1,270 @@
+// Package state implements the local state store of pigflux, that keeps the watermarks of incremental tests
+// and the checkpoints of backfills across restarts.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Value types
+const (
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeTime   = "time"
+	TypeString = "string"
+)
+
+// Value is a stored value. The type is kept, so that the value is passed back to the database with the same type.
+type Value struct {
+	Type    string    `json:"type"`
+	Value   string    `json:"value"`
+	Updated time.Time `json:"updated"`
+}
+
+// NewValue converts a value returned by a database driver.
+func NewValue(v interface{}) (Value, error) {
+	switch x := v.(type) {
+	case int64:
+		return Value{Type: TypeInt, Value: strconv.FormatInt(x, 10)}, nil
+	case int:
+		return Value{Type: TypeInt, Value: strconv.Itoa(x)}, nil
+	case int32:
+		return Value{Type: TypeInt, Value: strconv.FormatInt(int64(x), 10)}, nil
+	case float64:
+		return Value{Type: TypeFloat, Value: strconv.FormatFloat(x, 'g', -1, 64)}, nil
+	case float32:
+		return Value{Type: TypeFloat, Value: strconv.FormatFloat(float64(x), 'g', -1, 32)}, nil
+	case time.Time:
+		return Value{Type: TypeTime, Value: x.Format(time.RFC3339Nano)}, nil
+	case string:
+		return Value{Type: TypeString, Value: x}, nil
+	case []byte:
+		// some drivers return numbers as text
+		s := string(x)
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return Value{Type: TypeInt, Value: s}, nil
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return Value{Type: TypeFloat, Value: s}, nil
+		}
+		return Value{Type: TypeString, Value: s}, nil
+	case nil:
+		return Value{}, errors.New("value is null")
+	}
+	return Value{}, fmt.Errorf("unsupported value type %T", v)
+}
+
+// Any returns the value with its type.
+func (v Value) Any() (interface{}, error) {
+	switch v.Type {
+	case TypeInt:
+		return strconv.ParseInt(v.Value, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(v.Value, 64)
+	case TypeTime:
+		return time.Parse(time.RFC3339Nano, v.Value)
+	case TypeString:
+		return v.Value, nil
+	}
+	return nil, fmt.Errorf("unsupported value type %s", v.Type)
+}
+
+// Less tells if v is less than other. Numbers are compared as numbers, times as times, everything else as strings.
+func (v Value) Less(other Value) bool {
+	a, errA := v.Any()
+	b, errB := other.Any()
+	if errA == nil && errB == nil {
+		switch x := a.(type) {
+		case int64:
+			switch y := b.(type) {
+			case int64:
+				return x < y
+			case float64:
+				return float64(x) < y
+			}
+		case float64:
+			switch y := b.(type) {
+			case int64:
+				return x < float64(y)
+			case float64:
+				return x < y
+			}
+		case time.Time:
+			if y, ok := b.(time.Time); ok {
+				return x.Before(y)
+			}
+		}
+	}
+	return v.Value < other.Value
+}
+
+// Store is the state store. It is saved into a JSON file after each change. Without a file, the state is kept
+// in memory only.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	// Watermarks are keyed by test name and database name.
+	Watermarks map[string]map[string]Value `json:"watermarks"`
+	// Backfills are keyed by test name.
+	Backfills map[string]Checkpoint `json:"backfills"`
+}
+
+// Checkpoint is the progress of a backfill. Windows are done until Done.
+type Checkpoint struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Step    string    `json:"step"`
+	Done    time.Time `json:"done"`
+	Updated time.Time `json:"updated"`
+}
+
+// Open opens the state store. A missing file is an empty state. When path is empty, the state is not persisted.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, Watermarks: make(map[string]map[string]Value), Backfills: make(map[string]Checkpoint)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if s.Watermarks == nil {
+		s.Watermarks = make(map[string]map[string]Value)
+	}
+	if s.Backfills == nil {
+		s.Backfills = make(map[string]Checkpoint)
+	}
+	return s, nil
+}
+
+// Path returns the path of the state file, empty when the state is not persisted.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Watermark returns the watermark of a test on a database.
+func (s *Store) Watermark(testName string, dbname string) (Value, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.Watermarks[testName][dbname]
+	return v, ok
+}
+
+// SetWatermarks stores the watermarks of a test (keyed by database name), and saves the state.
+func (s *Store) SetWatermarks(testName string, values map[string]Value) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Watermarks[testName] == nil {
+		s.Watermarks[testName] = make(map[string]Value)
+	}
+	now := time.Now()
+	for dbname, v := range values {
+		v.Updated = now
+		s.Watermarks[testName][dbname] = v
+	}
+	return s.save()
+}
+
+// Checkpoint returns the checkpoint of the backfill of a test.
+func (s *Store) Checkpoint(testName string) (Checkpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.Backfills[testName]
+	return cp, ok
+}
+
+// SetCheckpoint stores the checkpoint of the backfill of a test, and saves the state.
+func (s *Store) SetCheckpoint(testName string, cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp.Updated = time.Now()
+	s.Backfills[testName] = cp
+	return s.save()
+}
+
+// RemoveCheckpoint removes the checkpoint of the backfill of a test (when it is finished), and saves the state.
+func (s *Store) RemoveCheckpoint(testName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Backfills[testName]; !ok {
+		return nil
+	}
+	delete(s.Backfills, testName)
+	return s.save()
+}
+
+// Reset removes watermarks and backfill checkpoints, and saves the state. Empty names match all tests or
+// databases, checkpoints are only removed when no database is given. It returns the number of removed entries.
+func (s *Store) Reset(testName string, dbname string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	if dbname == "" {
+		for tn := range s.Backfills {
+			if testName == "" || tn == testName {
+				delete(s.Backfills, tn)
+				count++
+			}
+		}
+	}
+	for tn, dbs := range s.Watermarks {
+		if testName != "" && tn != testName {
+			continue
+		}
+		for dn := range dbs {
+			if dbname != "" && dn != dbname {
+				continue
+			}
+			delete(dbs, dn)
+			count++
+		}
+		if len(dbs) == 0 {
+			delete(s.Watermarks, tn)
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, s.save()
+}
+
+// save writes the state into a temporary file, and renames it, so the state file is never partially written.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot save state: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("cannot save state: %w", err)
+	}
+	return nil
+}
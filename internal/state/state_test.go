@@ -0,0 +1,98 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Watermark("events", "db"); ok {
+		t.Fatal("a new store has no watermarks")
+	}
+	last := time.Date(2024, 5, 1, 12, 30, 0, 123, time.UTC)
+	err = store.SetWatermarks("events", map[string]Value{
+		"db":  {Type: TypeInt, Value: "42"},
+		"db2": {Type: TypeTime, Value: last.Format(time.RFC3339Nano)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := Checkpoint{From: last.Add(-24 * time.Hour), To: last, Step: "1h", Done: last.Add(-time.Hour)}
+	if err := store.SetCheckpoint("events", cp); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store opened from the same file has the same state
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, ok := store.Watermark("events", "db")
+	if !ok {
+		t.Fatal("watermark is lost")
+	}
+	if got, err := v.Any(); err != nil || got != int64(42) {
+		t.Errorf("got watermark %v (%v), want int64 42", got, err)
+	}
+	v, _ = store.Watermark("events", "db2")
+	if got, err := v.Any(); err != nil || !got.(time.Time).Equal(last) {
+		t.Errorf("got watermark %v (%v), want %v", got, err, last)
+	}
+	got, ok := store.Checkpoint("events")
+	if !ok || !got.Done.Equal(cp.Done) || got.Step != cp.Step || !got.From.Equal(cp.From) || !got.To.Equal(cp.To) {
+		t.Errorf("got checkpoint %+v, want %+v", got, cp)
+	}
+
+	count, err := store.Reset("events", "db")
+	if err != nil || count != 1 {
+		t.Fatalf("reset removed %d entries: %v", count, err)
+	}
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Watermark("events", "db"); ok {
+		t.Error("the reset watermark is still stored")
+	}
+	if _, ok := store.Watermark("events", "db2"); !ok {
+		t.Error("other watermarks should be kept")
+	}
+	matches, _ := filepath.Glob(path + ".*.tmp")
+	if len(matches) > 0 {
+		t.Errorf("temporary files are left: %v", matches)
+	}
+}
+
+func TestOpenInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("an invalid state file should be an error")
+	}
+}
+
+func TestValueLess(t *testing.T) {
+	tests := []struct {
+		a, b Value
+		want bool
+	}{
+		{Value{Type: TypeInt, Value: "9"}, Value{Type: TypeInt, Value: "10"}, true},
+		{Value{Type: TypeFloat, Value: "9.5"}, Value{Type: TypeInt, Value: "10"}, true},
+		{Value{Type: TypeTime, Value: "2024-05-02T00:00:00Z"}, Value{Type: TypeTime, Value: "2024-05-01T00:00:00+02:00"}, false},
+		{Value{Type: TypeString, Value: "b"}, Value{Type: TypeString, Value: "a"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Less(tt.b); got != tt.want {
+			t.Errorf("%v < %v: got %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}